@@ -0,0 +1,481 @@
+package isenzo
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+const (
+	// backtestSamples is the number of matched document fingerprints returned by a backtest.
+	backtestSamples = 10
+
+	// corpusQueueSize is the number of documents waiting to be recorded in the corpus.
+	corpusQueueSize = 1024
+)
+
+var (
+	// ErrorBacktestDisabled is returned when backtesting without a document corpus.
+	ErrorBacktestDisabled = errors.New("backtesting is not enabled")
+
+	// ErrorBacktestNested is returned when backtesting a nested query, as
+	// the corpus does not hold nested documents.
+	ErrorBacktestNested = errors.New("nested queries cannot be backtested")
+)
+
+// BacktestResult represents the documents of the corpus a query matched.
+//
+// Samples are the fingerprints of matched documents, as reported in shadow
+// query disagreements.
+type BacktestResult struct {
+	Query     string    `json:"query"`
+	Documents int       `json:"documents"`
+	Hits      int       `json:"hits"`
+	Samples   []string  `json:"samples"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+
+	hits         []string
+	fingerprints map[string]string
+}
+
+// BacktestComparison represents the difference between the corpus documents
+// matched by two queries.
+type BacktestComparison struct {
+	From           *BacktestResult `json:"from"`
+	To             *BacktestResult `json:"to"`
+	Both           int             `json:"both"`
+	Added          int             `json:"added"`
+	Removed        int             `json:"removed"`
+	AddedSamples   []string        `json:"added_samples"`
+	RemovedSamples []string        `json:"removed_samples"`
+}
+
+// CorpusStats represents the statistics of the backtest document corpus.
+type CorpusStats struct {
+	Documents int       `json:"documents"`
+	Dropped   int64     `json:"dropped"`
+	Errors    int64     `json:"errors"`
+	Oldest    time.Time `json:"oldest"`
+	Newest    time.Time `json:"newest"`
+}
+
+type corpusEntry struct {
+	id          string
+	fingerprint string
+	namespaces  []string
+	time        time.Time
+}
+
+type corpusDoc struct {
+	doc         *document.Document
+	fingerprint string
+	namespaces  []string
+	time        time.Time
+}
+
+// corpus is a rolling bleve index of matched documents, bounded by count and age.
+type corpus struct {
+	index  bleve.Index
+	size   int
+	window time.Duration
+
+	queue   chan corpusDoc
+	dropped int64
+	errors  int64
+
+	mu      sync.RWMutex
+	seq     uint64
+	entries []corpusEntry
+}
+
+// newCorpus creates a corpus indexing documents with the mapping.
+func newCorpus(m mapping.IndexMapping, size int, window time.Duration) (*corpus, error) {
+	i, err := bleve.NewMemOnly(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corpus{
+		index:  i,
+		size:   size,
+		window: window,
+		queue:  make(chan corpusDoc, corpusQueueSize),
+	}, nil
+}
+
+// record queues a mapped document matched against the namespaces to be
+// added to the corpus, dropping it if the queue is full.
+//
+// The document is copied before it is queued, as the mapped document is
+// owned by the caller. Only the document itself is recorded, not its nested
+// documents.
+func (c *corpus) record(mapped interface{}, namespaces []string, now time.Time) {
+	var src *document.Document
+	switch d := mapped.(type) {
+	case *document.Document:
+		src = d
+	case *matchers.NestedDocument:
+		src = d.Doc
+	default:
+		return
+	}
+
+	// Composite fields are updated while indexing, so are not reused.
+	doc := document.NewDocument("")
+	for _, f := range src.Fields {
+		if _, ok := f.(*document.CompositeField); ok {
+			continue
+		}
+		doc.AddField(f)
+	}
+	doc.AddField(document.NewCompositeField("_all", true, nil, nil))
+	for _, ns := range namespaces {
+		doc.AddField(newNamespaceField(ns))
+	}
+
+	d := corpusDoc{
+		doc:         doc,
+		fingerprint: fingerprint(src),
+		namespaces:  append([]string{}, namespaces...),
+		time:        now,
+	}
+	select {
+	case c.queue <- d:
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// add indexes a recorded document.
+func (c *corpus) add(d corpusDoc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	id := fmt.Sprintf("%020d", c.seq)
+	doc := d.doc
+	doc.ID = id
+
+	i, _, err := c.index.Advanced()
+	if err != nil {
+		return err
+	}
+	if err := i.Update(doc); err != nil {
+		return err
+	}
+
+	c.entries = append(c.entries, corpusEntry{
+		id:          id,
+		fingerprint: d.fingerprint,
+		namespaces:  d.namespaces,
+		time:        d.time,
+	})
+	return c.evict(d.time)
+}
+
+// evict deletes the documents exceeding the corpus size or older than its
+// window. The corpus lock must be held for writing.
+func (c *corpus) evict(now time.Time) error {
+	n := 0
+	for n < len(c.entries) {
+		e := c.entries[n]
+		if len(c.entries)-n <= c.size && (c.window <= 0 || now.Sub(e.time) <= c.window) {
+			break
+		}
+
+		if err := c.index.Delete(e.id); err != nil {
+			c.entries = c.entries[n:]
+			return err
+		}
+		n++
+	}
+	c.entries = c.entries[n:]
+
+	return nil
+}
+
+// search returns the ids of the documents matched against the namespace
+// that match each of the queries, newest first. The queries are run against
+// the same documents.
+func (c *corpus) search(ns string, qs []query.Query, now time.Time) ([]*BacktestResult, error) {
+	c.mu.Lock()
+	err := c.evict(now)
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	results := make([]*BacktestResult, len(qs))
+	for i, q := range qs {
+		if results[i], err = c.searchQuery(ns, q); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// searchQuery returns the ids of the documents matched against the namespace
+// that match the query, newest first. The corpus lock must be held.
+func (c *corpus) searchQuery(ns string, q query.Query) (*BacktestResult, error) {
+	res := &BacktestResult{Samples: []string{}, hits: []string{}, fingerprints: map[string]string{}}
+	for _, e := range c.entries {
+		if !hasNamespace(e.namespaces, ns) {
+			continue
+		}
+
+		if res.Documents == 0 {
+			res.From = e.time
+		}
+		res.To = e.time
+		res.Documents++
+	}
+	if res.Documents == 0 {
+		return res, nil
+	}
+
+	req := bleve.NewSearchRequestOptions(query.NewConjunctionQuery([]query.Query{
+		q,
+		newNamespaceQuery([]string{ns}),
+	}), res.Documents, 0, false)
+	req.SortBy([]string{"-_id"})
+
+	result, err := c.index.Search(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hit := range result.Hits {
+		res.hits = append(res.hits, hit.ID)
+		res.fingerprints[hit.ID] = c.fingerprint(hit.ID)
+	}
+	res.Hits = len(res.hits)
+	res.Samples = res.samples(res.hits)
+
+	return res, nil
+}
+
+// fingerprint returns the fingerprint of the document with the given id. The
+// corpus lock must be held.
+func (c *corpus) fingerprint(id string) string {
+	// Ids are zero padded sequence numbers, so the entries are sorted by id.
+	i := sort.Search(len(c.entries), func(i int) bool {
+		return c.entries[i].id >= id
+	})
+	if i < len(c.entries) && c.entries[i].id == id {
+		return c.entries[i].fingerprint
+	}
+
+	return ""
+}
+
+// stats returns the statistics of the corpus.
+func (c *corpus) stats() CorpusStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := CorpusStats{
+		Documents: len(c.entries),
+		Dropped:   atomic.LoadInt64(&c.dropped),
+		Errors:    atomic.LoadInt64(&c.errors),
+	}
+	if len(c.entries) > 0 {
+		stats.Oldest = c.entries[0].time
+		stats.Newest = c.entries[len(c.entries)-1].time
+	}
+
+	return stats
+}
+
+// close closes the corpus index.
+func (c *corpus) close() error {
+	return c.index.Close()
+}
+
+// samples returns the fingerprints of the first backtestSamples documents.
+func (r *BacktestResult) samples(ids []string) []string {
+	if len(ids) > backtestSamples {
+		ids = ids[:backtestSamples]
+	}
+
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, r.fingerprints[id])
+	}
+
+	return s
+}
+
+// hasNamespace determines if the namespace is in the namespaces.
+func hasNamespace(namespaces []string, ns string) bool {
+	for _, n := range namespaces {
+		if n == ns {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordCorpus adds the queued documents to the corpus until the Percolator
+// is done, counting the documents that could not be added.
+func (p *Percolator) recordCorpus() {
+	defer p.background.Done()
+
+	for {
+		select {
+		case d := <-p.corpus.queue:
+			if err := p.corpus.add(d); err != nil {
+				atomic.AddInt64(&p.corpus.errors, 1)
+				p.metrics.corpusErrors.Inc(1)
+			}
+
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// CorpusStats returns the statistics of the backtest document corpus.
+func (p *Percolator) CorpusStats() (CorpusStats, error) {
+	if p.corpus == nil {
+		return CorpusStats{}, ErrorBacktestDisabled
+	}
+
+	return p.corpus.stats(), nil
+}
+
+// Backtest matches a query against the documents recently matched by the Percolator.
+func (p *Percolator) Backtest(qry Query) (*BacktestResult, error) {
+	return p.backtest(DefaultNamespace, qry)
+}
+
+// backtest matches a query against the documents recently matched against a namespace.
+func (p *Percolator) backtest(ns string, qry Query) (*BacktestResult, error) {
+	res, err := p.backtestAll(ns, []Query{qry})
+	if err != nil {
+		return nil, err
+	}
+
+	return res[0], nil
+}
+
+// backtestAll matches queries against the same documents recently matched
+// against a namespace.
+func (p *Percolator) backtestAll(ns string, qrys []Query) ([]*BacktestResult, error) {
+	if err := p.begin(); err != nil {
+		return nil, err
+	}
+	defer p.end()
+
+	if p.corpus == nil {
+		return nil, ErrorBacktestDisabled
+	}
+
+	qs := make([]query.Query, len(qrys))
+	for i, qry := range qrys {
+		q, err := parseQuery(qry.Query)
+		if err != nil {
+			return nil, err
+		}
+
+		nested := false
+		walkQuery(q, func(q query.Query) query.Query {
+			if _, ok := q.(*matchers.NestedQuery); ok {
+				nested = true
+			}
+			return q
+		})
+		if nested {
+			return nil, ErrorBacktestNested
+		}
+
+		qs[i] = q
+	}
+
+	res, err := p.corpus.search(ns, qs, p.now())
+	if err != nil {
+		return nil, err
+	}
+	for i, qry := range qrys {
+		res[i].Query = qry.Query
+	}
+
+	return res, nil
+}
+
+// CompareBacktest compares the documents recently matched by the Percolator
+// that two queries match.
+func (p *Percolator) CompareBacktest(from, to Query) (*BacktestComparison, error) {
+	return p.compareBacktest(DefaultNamespace, from, to)
+}
+
+// compareBacktest compares the documents matched by two queries in a namespace.
+func (p *Percolator) compareBacktest(ns string, from, to Query) (*BacktestComparison, error) {
+	res, err := p.backtestAll(ns, []Query{from, to})
+	if err != nil {
+		return nil, err
+	}
+	a, b := res[0], res[1]
+
+	inFrom := make(map[string]bool, len(a.hits))
+	for _, id := range a.hits {
+		inFrom[id] = true
+	}
+
+	cmp := &BacktestComparison{From: a, To: b}
+	var added, removed []string
+	for _, id := range b.hits {
+		if inFrom[id] {
+			cmp.Both++
+			delete(inFrom, id)
+			continue
+		}
+		added = append(added, id)
+	}
+	for _, id := range a.hits {
+		if inFrom[id] {
+			removed = append(removed, id)
+		}
+	}
+
+	cmp.Added = len(added)
+	cmp.Removed = len(removed)
+	cmp.AddedSamples = b.samples(added)
+	cmp.RemovedSamples = a.samples(removed)
+
+	return cmp, nil
+}
+
+// BacktestVersions compares the documents recently matched by the Percolator
+// that two versions of the query with the given id match.
+func (p *Percolator) BacktestVersions(id string, from, to int) (*BacktestComparison, error) {
+	return p.backtestVersions(DefaultNamespace, id, from, to)
+}
+
+// backtestVersions compares the documents matched by two versions of a query in a namespace.
+func (p *Percolator) backtestVersions(ns, id string, from, to int) (*BacktestComparison, error) {
+	p.cacheLock.RLock()
+	a, okA := p.version(key(ns, id), from)
+	b, okB := p.version(key(ns, id), to)
+	p.cacheLock.RUnlock()
+
+	if !okA || !okB {
+		return nil, ErrorVersionNotFound
+	}
+
+	return p.compareBacktest(ns, a.Query, b.Query)
+}
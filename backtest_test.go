@@ -0,0 +1,162 @@
+package isenzo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_Backtest(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithBacktest(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	for i, v := range []string{"bar", "baz", "bar"} {
+		if _, err := p.Match(map[string]interface{}{"foo": v, "n": i}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+	waitForCorpus(t, p, 3)
+
+	res, err := p.Backtest(isenzo.NewQuery("1", "foo:bar"))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if res.Documents != 3 || res.Hits != 2 {
+		t.Fatalf("expected 2 hits in 3 documents; got %d in %d", res.Hits, res.Documents)
+	}
+
+	if len(res.Samples) != 2 || res.Samples[0] == "" || res.Samples[0] == res.Samples[1] {
+		t.Fatalf("expected 2 document fingerprints; got %v", res.Samples)
+	}
+}
+
+func TestPercolator_BacktestWindow(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	p, err := isenzo.NewPercolator(
+		isenzo.WithClock(func() time.Time { return now }),
+		isenzo.WithBacktest(2, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+	waitForCorpus(t, p, 2)
+
+	now = now.Add(2 * time.Hour)
+	res, err := p.Backtest(isenzo.NewQuery("1", "foo:bar"))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if res.Documents != 0 || res.Hits != 0 {
+		t.Fatalf("expected expired documents to be evicted; got %d hits in %d documents", res.Hits, res.Documents)
+	}
+}
+
+func TestPercolator_BacktestNamespaces(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithBacktest(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	a, _ := p.Namespace("a")
+	b, _ := p.Namespace("b")
+	if _, err := a.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	waitForCorpus(t, p, 1)
+
+	if res, err := a.Backtest(isenzo.NewQuery("1", "foo:bar")); err != nil || res.Hits != 1 {
+		t.Fatalf("expected %d hits; got %v, %v", 1, res, err)
+	}
+
+	if res, err := b.Backtest(isenzo.NewQuery("1", "foo:bar")); err != nil || res.Hits != 0 || res.Documents != 0 {
+		t.Fatalf("expected %d hits in %d documents; got %v, %v", 0, 0, res, err)
+	}
+}
+
+func TestPercolator_BacktestNested(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithBacktest(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.Backtest(isenzo.NewQuery("1", "+status:open +nested(items, color:red)")); err != isenzo.ErrorBacktestNested {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorBacktestNested, err)
+	}
+}
+
+func TestPercolator_BacktestVersions(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithBacktest(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar foo:baz")})
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:baz foo:qux")})
+
+	for _, v := range []string{"bar", "baz", "qux", "quux"} {
+		if _, err := p.Match(map[string]interface{}{"foo": v}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+	waitForCorpus(t, p, 4)
+
+	cmp, err := p.BacktestVersions("1", 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if cmp.From.Hits != 2 || cmp.To.Hits != 2 || cmp.Both != 1 || cmp.Added != 1 || cmp.Removed != 1 {
+		t.Fatalf("expected 1 shared, 1 added and 1 removed hit; got %+v", cmp)
+	}
+
+	if _, err := p.BacktestVersions("1", 1, 3); err != isenzo.ErrorVersionNotFound {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorVersionNotFound, err)
+	}
+}
+
+func TestPercolator_BacktestDisabled(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := p.Backtest(isenzo.NewQuery("1", "foo:bar")); err != isenzo.ErrorBacktestDisabled {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorBacktestDisabled, err)
+	}
+}
+
+// waitForCorpus waits for the backtest corpus to hold n documents.
+func waitForCorpus(t *testing.T, p *isenzo.Percolator, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := p.CorpusStats()
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if stats.Documents == n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d corpus documents; got %d", n, stats.Documents)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
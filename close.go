@@ -0,0 +1,95 @@
+package isenzo
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ErrorPercolatorClosed is returned by calls on a closed Percolator.
+var ErrorPercolatorClosed = errors.New("percolator is closed")
+
+const (
+	stateOpen = iota
+	stateDraining
+	stateClosed
+)
+
+// Close shuts the Percolator down, waiting for in-flight calls to finish.
+func (p *Percolator) Close() error {
+	return p.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting calls, waits for in-flight calls to finish and
+// releases the query index, the matcher factory and background goroutines.
+//
+// If the context is done before the in-flight calls have finished, its error
+// is returned and Shutdown can be called again to finish closing.
+func (p *Percolator) Shutdown(ctx context.Context) error {
+	p.stateLock.Lock()
+	switch p.state {
+	case stateClosed:
+		p.stateLock.Unlock()
+		return ErrorPercolatorClosed
+
+	case stateOpen:
+		p.state = stateDraining
+		close(p.done)
+	}
+	p.stateLock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		p.background.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	p.stateLock.Lock()
+	defer p.stateLock.Unlock()
+
+	if p.state == stateClosed {
+		return ErrorPercolatorClosed
+	}
+	p.state = stateClosed
+	p.changes.close()
+
+	err := p.queryIndex.Close()
+	if p.corpus != nil {
+		if cerr := p.corpus.close(); err == nil {
+			err = cerr
+		}
+	}
+	if c, ok := p.matcher.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// begin registers an in-flight call, returning an error if the Percolator
+// is shutting down. A successful begin must be followed by end.
+func (p *Percolator) begin() error {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+
+	if p.state != stateOpen {
+		return ErrorPercolatorClosed
+	}
+
+	p.inflight.Add(1)
+	return nil
+}
+
+// end marks an in-flight call as finished.
+func (p *Percolator) end() {
+	p.inflight.Done()
+}
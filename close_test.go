@@ -0,0 +1,112 @@
+package isenzo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+func TestPercolator_Close(t *testing.T) {
+	f := &closingFactory{Factory: matchers.NewIndexMatcherFactory(bleve.NewIndexMapping())}
+	p, err := isenzo.NewPercolator(isenzo.WithMatcherFactory(f), isenzo.WithJanitor(time.Millisecond))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if !f.closed {
+		t.Fatal("expected matcher factory to be closed")
+	}
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != isenzo.ErrorPercolatorClosed {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorPercolatorClosed, err)
+	}
+
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != isenzo.ErrorPercolatorClosed {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorPercolatorClosed, err)
+	}
+
+	if err := p.Close(); err != isenzo.ErrorPercolatorClosed {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorPercolatorClosed, err)
+	}
+}
+
+func TestPercolator_ShutdownDrains(t *testing.T) {
+	f := &closingFactory{
+		Factory: matchers.NewIndexMatcherFactory(bleve.NewIndexMapping()),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	p, err := isenzo.NewPercolator(isenzo.WithMatcherFactory(f))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := p.Match(map[string]interface{}{"foo": "bar"})
+		done <- err
+	}()
+	<-f.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	if f.closed {
+		t.Fatal("expected matcher factory to be open while draining")
+	}
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != isenzo.ErrorPercolatorClosed {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorPercolatorClosed, err)
+	}
+
+	close(f.release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if !f.closed {
+		t.Fatal("expected matcher factory to be closed")
+	}
+}
+
+type closingFactory struct {
+	matchers.Factory
+
+	started chan struct{}
+	release chan struct{}
+	closed  bool
+}
+
+func (f *closingFactory) New(doc interface{}) (matchers.Matcher, error) {
+	if f.started != nil {
+		close(f.started)
+		<-f.release
+	}
+
+	return f.Factory.New(doc)
+}
+
+func (f *closingFactory) Close() error {
+	f.closed = true
+
+	return f.Factory.(*matchers.IndexMatcherFactory).Close()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/server"
+	"github.com/spf13/cobra"
+)
+
+const (
+	FlagRules   = "rules"
+	FlagInput   = "input"
+	FlagSummary = "summary"
+)
+
+var percolateCmd = &cobra.Command{
+	Use:   "percolate",
+	Short: "Percolate a JSON lines file of documents against a rule set",
+	RunE:  runPercolate,
+}
+
+func init() {
+	flags := percolateCmd.Flags()
+	flags.String(FlagRules, "", "The path to the rules file (.jsonl, .yaml or .csv).")
+	flags.String(FlagInput, "-", "The path to the JSON lines documents file, or - for stdin.")
+	flags.Bool(FlagSummary, false, "Write a summary report to stderr.")
+
+	rootCmd.AddCommand(percolateCmd)
+}
+
+func runPercolate(cmd *cobra.Command, args []string) error {
+	rulesPath, _ := cmd.Flags().GetString(FlagRules)
+	if rulesPath == "" {
+		return errors.New("a rules file is required")
+	}
+
+	qrys, err := loadRules(rulesPath)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPercolator(nil)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	if err := p.Update(qrys); err != nil {
+		return err
+	}
+
+	in := io.Reader(os.Stdin)
+	if path, _ := cmd.Flags().GetString(FlagInput); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	sum := newSummary(qrys)
+	if err := percolate(p, in, os.Stdout, sum); err != nil {
+		return err
+	}
+
+	if ok, _ := cmd.Flags().GetBool(FlagSummary); ok {
+		sum.Write(os.Stderr)
+	}
+
+	return nil
+}
+
+// percolate matches each document in the JSON lines input, writing the results as JSON lines.
+func percolate(p *isenzo.Percolator, r io.Reader, w io.Writer, sum *summary) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	enc := json.NewEncoder(bw)
+	for {
+		var doc json.RawMessage
+		if err := dec.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		res, err := p.Match(doc)
+		if err != nil {
+			return err
+		}
+
+		sum.Add(res)
+
+		errs := make([]string, len(res.Errs))
+		for i, err := range res.Errs {
+			errs[i] = err.Error()
+		}
+		if err := enc.Encode(server.Results{
+			Ids:        res.Ids,
+			Errs:       errs,
+			Took:       res.Took,
+			QueriesRun: res.QueriesRun,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// summary collects statistics over percolated documents.
+type summary struct {
+	ids       []string
+	hits      map[string]int
+	errors    int
+	latencies []time.Duration
+}
+
+func newSummary(qrys []isenzo.Query) *summary {
+	s := &summary{
+		ids:  make([]string, len(qrys)),
+		hits: make(map[string]int, len(qrys)),
+	}
+
+	for i, qry := range qrys {
+		s.ids[i] = qry.Id
+	}
+	sort.Strings(s.ids)
+
+	return s
+}
+
+// Add adds the results of a document to the summary.
+func (s *summary) Add(res *isenzo.Results) {
+	for _, id := range res.Ids {
+		s.hits[id]++
+	}
+
+	s.errors += len(res.Errs)
+	s.latencies = append(s.latencies, res.Took)
+}
+
+// Percentile returns the match latency at percentile q (0-100).
+func (s *summary) Percentile(q float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+
+	l := make([]time.Duration, len(s.latencies))
+	copy(l, s.latencies)
+	sort.Slice(l, func(i, j int) bool {
+		return l[i] < l[j]
+	})
+
+	idx := int(q / 100 * float64(len(l)-1))
+	return l[idx]
+}
+
+// Write writes the summary report to w.
+func (s *summary) Write(w io.Writer) {
+	fmt.Fprintf(w, "documents: %d\n", len(s.latencies))
+	fmt.Fprintf(w, "errors:    %d\n", s.errors)
+	fmt.Fprintf(w, "latency:   p50=%s p90=%s p99=%s max=%s\n\n",
+		s.Percentile(50), s.Percentile(90), s.Percentile(99), s.Percentile(100))
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RULE\tHITS")
+	for _, id := range s.ids {
+		fmt.Fprintf(tw, "%s\t%d\n", id, s.hits[id])
+	}
+	tw.Flush()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolate(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	p.Update(testRules)
+
+	in := `{"foo":"bar"}
+{"foo":"baz"}
+`
+	out := &bytes.Buffer{}
+	sum := newSummary(testRules)
+
+	if err := percolate(p, strings.NewReader(in), out, sum); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if lines := strings.Count(out.String(), "\n"); lines != 2 {
+		t.Fatalf("expected %d result lines; got %d", 2, lines)
+	}
+
+	if sum.hits["1"] != 1 {
+		t.Fatalf("expected %d hits; got %d", 1, sum.hits["1"])
+	}
+}
+
+func TestSummary_Percentile(t *testing.T) {
+	sum := newSummary(nil)
+	for i := 1; i <= 100; i++ {
+		sum.Add(&isenzo.Results{Took: time.Duration(i)})
+	}
+
+	if p := sum.Percentile(50); p != 50 {
+		t.Fatalf("expected p50 %d; got %d", 50, p)
+	}
+
+	if p := sum.Percentile(100); p != 100 {
+		t.Fatalf("expected max %d; got %d", 100, p)
+	}
+}
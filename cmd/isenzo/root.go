@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/matchers"
+	"github.com/nrwiersma/isenzo/presearchers"
+	"github.com/rcrowley/go-metrics"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	FlagConfig      = "config"
+	FlagMapping     = "mapping"
+	FlagThreads     = "threads"
+	FlagMatcher     = "matcher"
+	FlagPresearcher = "presearcher"
+	FlagQueryBudget = "query-budget"
+	FlagSlowQuery   = "slow-query"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "isenzo",
+	Short: "isenzo is a bleve based percolator",
+}
+
+func init() {
+	cobra.OnInitialize(initConfig)
+
+	flags := rootCmd.PersistentFlags()
+	flags.String(FlagConfig, "", "The path to the config file.")
+	flags.String(FlagMapping, "", "The path to a bleve index mapping JSON file.")
+	flags.Int(FlagThreads, 1, "The number of matcher threads per document.")
+	flags.String(FlagMatcher, "parallel", "The threaded matcher strategy to use (parallel, partitioned).")
+	flags.String(FlagPresearcher, "term", "The presearcher to use (term, none).")
+	flags.Duration(FlagQueryBudget, 0, "The time budget of a single query, or 0 for none.")
+	flags.Duration(FlagSlowQuery, 0, "The slow query log threshold, or 0 to disable.")
+
+	viper.BindPFlag(FlagMapping, flags.Lookup(FlagMapping))
+	viper.BindPFlag(FlagThreads, flags.Lookup(FlagThreads))
+	viper.BindPFlag(FlagMatcher, flags.Lookup(FlagMatcher))
+	viper.BindPFlag(FlagPresearcher, flags.Lookup(FlagPresearcher))
+	viper.BindPFlag(FlagQueryBudget, flags.Lookup(FlagQueryBudget))
+	viper.BindPFlag(FlagSlowQuery, flags.Lookup(FlagSlowQuery))
+}
+
+func initConfig() {
+	viper.SetEnvPrefix("isenzo")
+	viper.AutomaticEnv()
+
+	if file, _ := rootCmd.PersistentFlags().GetString(FlagConfig); file != "" {
+		viper.SetConfigFile(file)
+		if err := viper.ReadInConfig(); err != nil {
+			fmt.Printf("could not read config: %v\n", err)
+		}
+	}
+}
+
+// newPercolator creates a Percolator from the configuration.
+func newPercolator(r metrics.Registry) (*isenzo.Percolator, error) {
+	m, err := loadMapping(viper.GetString(FlagMapping))
+	if err != nil {
+		return nil, err
+	}
+
+	var factory matchers.Factory
+	factory = matchers.NewIndexMatcherFactory(m, matchers.WithBudget(viper.GetDuration(FlagQueryBudget)))
+	if threads := viper.GetInt(FlagThreads); threads > 1 {
+		switch name := viper.GetString(FlagMatcher); name {
+		case "parallel", "":
+			factory = matchers.NewParallelMatcherFactory(factory, threads)
+		case "partitioned":
+			factory = matchers.NewPartitionedMatcherFactory(factory, threads)
+		default:
+			return nil, fmt.Errorf("unknown matcher %q", name)
+		}
+	}
+
+	var presearcher presearchers.Presearcher
+	switch name := viper.GetString(FlagPresearcher); name {
+	case "term":
+		presearcher = &presearchers.TermPresearcher{}
+	case "none", "":
+		presearcher = nil
+	default:
+		return nil, fmt.Errorf("unknown presearcher %q", name)
+	}
+
+	return isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(factory),
+		isenzo.WithPresearcher(presearcher),
+		isenzo.WithMetrics(r),
+		isenzo.WithSlowQueryLog(viper.GetDuration(FlagSlowQuery), log.New(os.Stderr, "", log.LstdFlags)),
+	)
+}
+
+// loadMapping loads an index mapping from a JSON file.
+func loadMapping(path string) (mapping.IndexMapping, error) {
+	m := bleve.NewIndexMapping()
+	if path == "" {
+		return m, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("could not parse mapping: %v", err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nrwiersma/isenzo"
+	"gopkg.in/yaml.v2"
+)
+
+// loadRules loads the queries from a file, picking the format from the file extension.
+func loadRules(path string) ([]isenzo.Query, error) {
+	var read func(io.Reader) ([]isenzo.Query, error)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json", ".jsonl":
+		read = readJSONRules
+	case ".yaml", ".yml":
+		read = readYAMLRules
+	case ".csv":
+		read = readCSVRules
+	default:
+		return nil, fmt.Errorf("unknown rules format %q", ext)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return read(f)
+}
+
+// readJSONRules reads JSON lines of queries.
+func readJSONRules(r io.Reader) ([]isenzo.Query, error) {
+	var qrys []isenzo.Query
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var qry isenzo.Query
+		if err := dec.Decode(&qry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		qrys = append(qrys, qry)
+	}
+
+	return qrys, nil
+}
+
+// readYAMLRules reads a YAML list of queries.
+func readYAMLRules(r io.Reader) ([]isenzo.Query, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []struct {
+		Id    string `yaml:"id"`
+		Query string `yaml:"query"`
+	}
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return nil, err
+	}
+
+	qrys := make([]isenzo.Query, len(rules))
+	for i, rule := range rules {
+		qrys[i] = isenzo.NewQuery(rule.Id, rule.Query)
+	}
+
+	return qrys, nil
+}
+
+// readCSVRules reads CSV records of id and query, with an optional header.
+func readCSVRules(r io.Reader) ([]isenzo.Query, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) > 0 && records[0][0] == "id" && records[0][1] == "query" {
+		records = records[1:]
+	}
+
+	qrys := make([]isenzo.Query, len(records))
+	for i, rec := range records {
+		qrys[i] = isenzo.NewQuery(rec[0], rec[1])
+	}
+
+	return qrys, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+var testRules = []isenzo.Query{
+	isenzo.NewQuery("1", "foo:bar"),
+	isenzo.NewQuery("2", "baz AND bat"),
+}
+
+func TestReadJSONRules(t *testing.T) {
+	in := `{"id":"1","query":"foo:bar"}
+{"id":"2","query":"baz AND bat"}
+`
+
+	qrys, err := readJSONRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if !reflect.DeepEqual(qrys, testRules) {
+		t.Fatalf("expected %v; got %v", testRules, qrys)
+	}
+}
+
+func TestReadYAMLRules(t *testing.T) {
+	in := `- id: "1"
+  query: foo:bar
+- id: "2"
+  query: baz AND bat
+`
+
+	qrys, err := readYAMLRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if !reflect.DeepEqual(qrys, testRules) {
+		t.Fatalf("expected %v; got %v", testRules, qrys)
+	}
+}
+
+func TestReadCSVRules(t *testing.T) {
+	in := `id,query
+1,foo:bar
+2,baz AND bat
+`
+
+	qrys, err := readCSVRules(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if !reflect.DeepEqual(qrys, testRules) {
+		t.Fatalf("expected %v; got %v", testRules, qrys)
+	}
+}
+
+func TestLoadRulesUnknownFormat(t *testing.T) {
+	_, err := loadRules("rules.txt")
+	if err == nil {
+		t.Fatal("expected errors; got none")
+	}
+}
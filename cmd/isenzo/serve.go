@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nrwiersma/isenzo/prometheus"
+	"github.com/nrwiersma/isenzo/replication"
+	"github.com/nrwiersma/isenzo/server"
+	"github.com/rcrowley/go-metrics"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	FlagAddr            = "addr"
+	FlagShutdownTimeout = "shutdown-timeout"
+	FlagFollow          = "follow"
+	FlagLeaderAddr      = "leader-addr"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the percolator http server",
+	Long: `Run the percolator http server.
+
+With --leader-addr the replication leader endpoints are served under
+/replication/ on a separate address. They stream every query without
+authentication, so the address should only be reachable by followers.
+The leader is not served by default.`,
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().String(FlagAddr, ":8080", "The address to listen on.")
+	serveCmd.Flags().Duration(FlagShutdownTimeout, 30*time.Second, "The time to wait for in-flight requests on shutdown.")
+	serveCmd.Flags().String(FlagFollow, "", "The url of a leader to replicate queries from.")
+	serveCmd.Flags().String(FlagLeaderAddr, "", "The address to serve the unauthenticated replication leader on, or empty to disable it.")
+	viper.BindPFlag(FlagAddr, serveCmd.Flags().Lookup(FlagAddr))
+	viper.BindPFlag(FlagFollow, serveCmd.Flags().Lookup(FlagFollow))
+	viper.BindPFlag(FlagLeaderAddr, serveCmd.Flags().Lookup(FlagLeaderAddr))
+	viper.BindPFlag(FlagShutdownTimeout, serveCmd.Flags().Lookup(FlagShutdownTimeout))
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	r := metrics.NewRegistry()
+	p, err := newPercolator(r)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", prometheus.Handler(r, "isenzo"))
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	var leader *replication.Leader
+	var leaderSrv *http.Server
+	if url := viper.GetString(FlagFollow); url != "" {
+		// Queries are written on the leader, so a follower is read only and
+		// does not serve as a leader itself.
+		mux.Handle("/", server.New(p, server.WithReadOnly()))
+
+		f := replication.NewFollower(p, url+"/replication")
+		mux.HandleFunc("/replication/status", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(f.Status())
+		})
+
+		go f.Run(ctx)
+		log.Printf("isenzo: following %s", url)
+	} else {
+		mux.Handle("/", server.New(p))
+
+		// The leader streams every query without authentication, so it is
+		// only served when asked for, on its own address.
+		if addr := viper.GetString(FlagLeaderAddr); addr != "" {
+			leader = replication.NewLeader(p, 10*time.Second)
+
+			leaderMux := http.NewServeMux()
+			leaderMux.Handle("/replication/", http.StripPrefix("/replication", leader))
+			leaderSrv = &http.Server{Addr: addr, Handler: leaderMux}
+		}
+	}
+
+	srv := &http.Server{Addr: viper.GetString(FlagAddr), Handler: mux}
+
+	errCh := make(chan error, 2)
+	go func() {
+		log.Printf("isenzo: listening on %s", srv.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	if leaderSrv != nil {
+		go func() {
+			log.Printf("isenzo: serving replication leader on %s", leaderSrv.Addr)
+			errCh <- leaderSrv.ListenAndServe()
+		}()
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		if leader != nil {
+			leader.Close()
+			leaderSrv.Close()
+		}
+		srv.Close()
+		p.Close()
+		return err
+
+	case <-sigCh:
+	}
+
+	log.Printf("isenzo: shutting down")
+	stop()
+	if leader != nil {
+		leader.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration(FlagShutdownTimeout))
+	defer cancel()
+
+	if leaderSrv != nil {
+		if err := leaderSrv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	return p.Shutdown(ctx)
+}
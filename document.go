@@ -0,0 +1,50 @@
+package isenzo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var (
+	ErrorInvalidDocument = errors.New("document must be a JSON object")
+	ErrorTrailingData    = errors.New("unexpected data after document")
+)
+
+// decodeDocument decodes raw JSON documents. Any other document is returned
+// as is, to be mapped by the matcher.
+//
+// Raw JSON must hold a single object, decoded into a map as the index mapping
+// walks documents by reflection.
+func decodeDocument(doc interface{}) (interface{}, error) {
+	var b []byte
+	switch d := doc.(type) {
+	case json.RawMessage:
+		b = d
+	case []byte:
+		b = d
+	default:
+		return doc, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return nil, ErrorInvalidDocument
+		}
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, ErrorInvalidDocument
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, ErrorTrailingData
+	}
+
+	return m, nil
+}
@@ -0,0 +1,79 @@
+package isenzo_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/nrwiersma/isenzo"
+)
+
+type testDocument struct {
+	Foo string `json:"foo"`
+	Bar string `json:"-"`
+}
+
+func TestPercolator_MatchDocumentTypes(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	mapped := document.NewDocument("doc")
+	if err := bleve.NewIndexMapping().MapDocument(mapped, map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	docs := []interface{}{
+		map[string]interface{}{"foo": "bar"},
+		testDocument{Foo: "bar"},
+		&testDocument{Foo: "bar"},
+		json.RawMessage(`{"foo":"bar"}`),
+		[]byte(`{"foo":"bar"}`),
+		mapped,
+	}
+	for _, doc := range docs {
+		results, err := p.Match(doc)
+		if err != nil {
+			t.Fatalf("unexpected err for %T; got %v", doc, err)
+		}
+
+		if len(results.Ids) != 1 {
+			t.Fatalf("expected %d results for %T; got %v", 1, doc, len(results.Ids))
+		}
+	}
+}
+
+func TestPercolator_MatchInvalidJSON(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := p.Match([]byte(`{"foo":`)); err == nil {
+		t.Fatal("expected errors; got none")
+	}
+
+	tests := []struct {
+		doc string
+		err error
+	}{
+		{`[1,2]`, isenzo.ErrorInvalidDocument},
+		{`null`, isenzo.ErrorInvalidDocument},
+		{`{"foo":"bar"} garbage`, isenzo.ErrorTrailingData},
+		{`{"foo":"bar"}{}`, isenzo.ErrorTrailingData},
+	}
+	for _, tt := range tests {
+		if _, err := p.Match(json.RawMessage(tt.doc)); err != tt.err {
+			t.Fatalf("expected %v for %s; got %v", tt.err, tt.doc, err)
+		}
+	}
+}
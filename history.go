@@ -0,0 +1,371 @@
+package isenzo
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultHistorySize is the default number of versions kept per query.
+	defaultHistorySize = 10
+
+	// defaultDeletedHistorySize is the default number of deleted queries
+	// whose history is kept.
+	defaultDeletedHistorySize = 1024
+)
+
+// ErrorVersionNotFound is returned when a query version is not in the history.
+var ErrorVersionNotFound = errors.New("query version not found")
+
+// QueryVersion represents a version of a query.
+type QueryVersion struct {
+	Version int `json:"version"`
+	// Revision is the change revision that created the version.
+	Revision uint64 `json:"revision"`
+	Query    Query  `json:"query"`
+	// Deleted determines if the version is a deletion of the query.
+	Deleted bool      `json:"deleted,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// QueryDiff represents the differences between two versions of a query.
+type QueryDiff struct {
+	Id     string      `json:"id"`
+	From   int         `json:"from"`
+	To     int         `json:"to"`
+	Fields []FieldDiff `json:"fields"`
+	// Query is the word diff of the query strings.
+	Query []DiffOp `json:"query"`
+}
+
+// FieldDiff represents a changed field between two query versions.
+type FieldDiff struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// DiffOp represents a word of a query diff, with the operation "=" for an
+// unchanged word, "-" for a removed word and "+" for an added word.
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// recordVersion adds a version to the history of a query, dropping the
+// oldest version when the history is full. The cache lock must be held for
+// writing.
+func (p *Percolator) recordVersion(k string, v QueryVersion) {
+	versions := p.history[k]
+
+	v.Version = 1
+	if n := len(versions); n > 0 {
+		v.Version = versions[n-1].Version + 1
+	}
+
+	versions = append(versions, v)
+	if size := p.historySize; size > 0 && len(versions) > size {
+		versions = versions[len(versions)-size:]
+	}
+	p.history[k] = versions
+
+	if v.Deleted {
+		p.deleted = append(p.deleted, k)
+		p.dropDeletedHistory()
+	}
+}
+
+// dropDeletedHistory drops the histories of the oldest deleted queries
+// beyond the deleted history size. The cache lock must be held for writing.
+func (p *Percolator) dropDeletedHistory() {
+	for p.deletedSize > 0 && len(p.deleted) > p.deletedSize {
+		k := p.deleted[0]
+		p.deleted[0] = ""
+		p.deleted = p.deleted[1:]
+
+		// The query may have been added again since it was deleted.
+		versions := p.history[k]
+		last := versions[len(versions)-1]
+		if _, ok := p.cache[k]; ok || !last.Deleted {
+			continue
+		}
+
+		delete(p.history, k)
+		if last.Revision > p.historyDropped {
+			p.historyDropped = last.Revision
+		}
+	}
+}
+
+// version returns a version of a query. The cache lock must be held.
+func (p *Percolator) version(k string, version int) (QueryVersion, bool) {
+	for _, v := range p.history[k] {
+		if v.Version == version {
+			return v, true
+		}
+	}
+
+	return QueryVersion{}, false
+}
+
+// History returns the versions of the query with the given id, oldest first.
+func (p *Percolator) History(id string) []QueryVersion {
+	return p.queryHistory(DefaultNamespace, id)
+}
+
+// queryHistory returns the versions of a query in a namespace, oldest first.
+func (p *Percolator) queryHistory(ns, id string) []QueryVersion {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	versions := p.history[key(ns, id)]
+	return append(make([]QueryVersion, 0, len(versions)), versions...)
+}
+
+// Diff returns the differences between two versions of the query with the given id.
+func (p *Percolator) Diff(id string, from, to int) (*QueryDiff, error) {
+	return p.diff(DefaultNamespace, id, from, to)
+}
+
+// diff returns the differences between two versions of a query in a namespace.
+func (p *Percolator) diff(ns, id string, from, to int) (*QueryDiff, error) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	k := key(ns, id)
+	a, ok := p.version(k, from)
+	if !ok {
+		return nil, ErrorVersionNotFound
+	}
+	b, ok := p.version(k, to)
+	if !ok {
+		return nil, ErrorVersionNotFound
+	}
+
+	d := &QueryDiff{
+		Id:     id,
+		From:   from,
+		To:     to,
+		Fields: []FieldDiff{},
+		Query:  diffWords(a.Query.Query, b.Query.Query),
+	}
+
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"query", a.Query.Query, b.Query.Query},
+		{"author", a.Query.Author, b.Query.Author},
+		{"active_from", formatTime(a.Query.ActiveFrom), formatTime(b.Query.ActiveFrom)},
+		{"expires_at", formatTime(a.Query.ExpiresAt), formatTime(b.Query.ExpiresAt)},
+		{"schedule", formatSchedule(a.Query.Schedule), formatSchedule(b.Query.Schedule)},
+		{"deleted", formatBool(a.Deleted), formatBool(b.Deleted)},
+	}
+	for _, f := range fields {
+		if f.from != f.to {
+			d.Fields = append(d.Fields, FieldDiff{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+
+	return d, nil
+}
+
+// Rollback restores the query with the given id to a previous version,
+// recording it as a new version.
+func (p *Percolator) Rollback(id string, version int) error {
+	return p.rollback(DefaultNamespace, id, version)
+}
+
+// rollback restores a query in a namespace to a previous version.
+func (p *Percolator) rollback(ns, id string, version int) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	k := key(ns, id)
+	v, ok := p.version(k, version)
+	if !ok {
+		return ErrorVersionNotFound
+	}
+
+	if v.Deleted {
+		return p.delete(k)
+	}
+
+	return p.updateLocked(ns, []Query{v.Query})
+}
+
+// RollbackTo restores the queries of all namespaces to their versions at
+// the given change revision, recording the restored queries as new versions.
+//
+// If the history of a query no longer reaches back to the revision, or was
+// dropped after the query was deleted, ErrorVersionNotFound is returned and
+// no query is changed. The same holds for any other error, such as a
+// namespace limit the restored queries would exceed.
+func (p *Percolator) RollbackTo(revision uint64) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	// A dropped history may be of a query that existed at the revision.
+	if revision < p.historyDropped {
+		return ErrorVersionNotFound
+	}
+
+	keys := make([]string, 0, len(p.history))
+	for k := range p.history {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// Plan the changes before applying any of them.
+	type update struct {
+		ns  string
+		qry Query
+	}
+
+	var deletes []string
+	var updates []update
+	for _, k := range keys {
+		versions := p.history[k]
+
+		i := sort.Search(len(versions), func(i int) bool {
+			return versions[i].Revision > revision
+		})
+		if i == 0 && versions[0].Version > 1 {
+			return ErrorVersionNotFound
+		}
+
+		c, exists := p.cache[k]
+		switch {
+		case i == 0 || versions[i-1].Deleted:
+			if exists {
+				deletes = append(deletes, k)
+			}
+
+		case !exists || !reflect.DeepEqual(c.query, versions[i-1].Query):
+			ns, _ := splitKey(k)
+			updates = append(updates, update{ns: ns, qry: versions[i-1].Query})
+		}
+	}
+
+	// Validate the plan, so it is applied as a whole or not at all.
+	added := map[string]int{}
+	for _, k := range deletes {
+		ns, _ := splitKey(k)
+		added[ns]--
+	}
+	for _, u := range updates {
+		if _, err := p.newCachedQuery(u.ns, u.qry); err != nil {
+			return err
+		}
+
+		if _, ok := p.cache[key(u.ns, u.qry.Id)]; !ok {
+			added[u.ns]++
+		}
+	}
+	for ns, n := range added {
+		state := p.namespace(ns)
+		if limit := p.limit(state); limit > 0 && n > 0 && state.queries+n > limit {
+			return ErrorNamespaceLimit
+		}
+	}
+
+	for _, k := range deletes {
+		if err := p.delete(k); err != nil {
+			return err
+		}
+	}
+
+	for _, u := range updates {
+		if err := p.updateLocked(u.ns, []Query{u.qry}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// diffWords returns the word diff between two strings.
+func diffWords(a, b string) []DiffOp {
+	x, y := strings.Fields(a), strings.Fields(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := make([]DiffOp, 0, len(x)+len(y))
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			ops = append(ops, DiffOp{Op: "=", Text: x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, DiffOp{Op: "-", Text: x[i]})
+			i++
+		default:
+			ops = append(ops, DiffOp{Op: "+", Text: y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		ops = append(ops, DiffOp{Op: "-", Text: x[i]})
+	}
+	for ; j < len(y); j++ {
+		ops = append(ops, DiffOp{Op: "+", Text: y[j]})
+	}
+
+	return ops
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func formatSchedule(s *Schedule) string {
+	if s == nil {
+		return ""
+	}
+
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func formatBool(b bool) string {
+	if b {
+		return "true"
+	}
+
+	return "false"
+}
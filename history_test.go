@@ -0,0 +1,211 @@
+package isenzo_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_History(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithHistory(3))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for _, q := range []string{"a:1", "a:2", "a:3", "a:4"} {
+		qry := isenzo.NewQuery("1", q)
+		qry.Author = "alice"
+		p.Update([]isenzo.Query{qry})
+	}
+	p.Delete([]string{"1"})
+
+	versions := p.History("1")
+	if len(versions) != 3 {
+		t.Fatalf("expected %d versions; got %d", 3, len(versions))
+	}
+
+	if versions[0].Version != 3 || versions[0].Query.Query != "a:3" || versions[0].Query.Author != "alice" {
+		t.Fatalf("expected version 3 of a:3 by alice; got %+v", versions[0])
+	}
+
+	if !versions[2].Deleted || versions[2].Version != 5 {
+		t.Fatalf("expected version 5 to be a deletion; got %+v", versions[2])
+	}
+}
+
+func TestPercolator_HistoryIdenticalUpdate(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "a:1")}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+
+	if versions := p.History("1"); len(versions) != 1 {
+		t.Fatalf("expected %d versions; got %+v", 1, versions)
+	}
+	if rev := p.Revision(); rev != 1 {
+		t.Fatalf("expected revision %d; got %d", 1, rev)
+	}
+}
+
+func TestPercolator_Diff(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "+status:open +foo:bar")})
+	updated := isenzo.NewQuery("1", "+status:open +foo:baz")
+	updated.Author = "bob"
+	p.Update([]isenzo.Query{updated})
+
+	d, err := p.Diff("1", 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	want := []isenzo.DiffOp{
+		{Op: "=", Text: "+status:open"},
+		{Op: "-", Text: "+foo:bar"},
+		{Op: "+", Text: "+foo:baz"},
+	}
+	if !reflect.DeepEqual(d.Query, want) {
+		t.Fatalf("expected query diff %v; got %v", want, d.Query)
+	}
+
+	if len(d.Fields) != 2 || d.Fields[0].Field != "query" || d.Fields[1].Field != "author" || d.Fields[1].To != "bob" {
+		t.Fatalf("expected query and author field diffs; got %+v", d.Fields)
+	}
+
+	if _, err := p.Diff("1", 1, 3); err != isenzo.ErrorVersionNotFound {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorVersionNotFound, err)
+	}
+}
+
+func TestPercolator_Rollback(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")})
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:baz")})
+
+	if err := p.Rollback("1", 1); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if q, _ := p.Query("1"); q.Query != "foo:bar" {
+		t.Fatalf("expected query foo:bar; got %s", q.Query)
+	}
+
+	if versions := p.History("1"); len(versions) != 3 || versions[2].Query.Query != "foo:bar" {
+		t.Fatalf("expected rollback to be recorded as version 3; got %+v", versions)
+	}
+
+	res, err := p.Match(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if len(res.Ids) != 1 {
+		t.Fatalf("expected %d results; got %v", 1, res.Ids)
+	}
+
+	if err := p.Rollback("1", 9); err != isenzo.ErrorVersionNotFound {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorVersionNotFound, err)
+	}
+}
+
+func TestPercolator_RollbackTo(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), isenzo.NewQuery("2", "foo:bar")})
+	rev := p.Revision()
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:baz"), isenzo.NewQuery("3", "foo:bar")})
+	p.Delete([]string{"2"})
+
+	if err := p.RollbackTo(rev); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	qrys := p.Queries()
+	if len(qrys) != 2 || qrys[0].Id != "1" || qrys[0].Query != "foo:bar" || qrys[1].Id != "2" {
+		t.Fatalf("expected queries 1 and 2 at revision %d; got %v", rev, qrys)
+	}
+}
+
+func TestPercolator_RollbackToTrimmedHistory(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithHistory(1))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")})
+	rev := p.Revision()
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:baz")})
+
+	if err := p.RollbackTo(rev); err != isenzo.ErrorVersionNotFound {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorVersionNotFound, err)
+	}
+
+	if q, _ := p.Query("1"); q.Query != "foo:baz" {
+		t.Fatalf("expected query to be unchanged; got %s", q.Query)
+	}
+}
+
+func TestPercolator_RollbackToOverLimit(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), isenzo.NewQuery("2", "foo:bar")})
+	rev := p.Revision()
+	p.Delete([]string{"1", "2"})
+	p.Update([]isenzo.Query{isenzo.NewQuery("3", "foo:bar")})
+	p.SetNamespaceLimit(isenzo.DefaultNamespace, 1)
+
+	if err := p.RollbackTo(rev); err != isenzo.ErrorNamespaceLimit {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorNamespaceLimit, err)
+	}
+
+	if qrys := p.Queries(); len(qrys) != 1 || qrys[0].Id != "3" {
+		t.Fatalf("expected queries to be unchanged; got %v", qrys)
+	}
+}
+
+func TestPercolator_RollbackToDroppedHistory(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithDeletedHistory(1))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), isenzo.NewQuery("2", "foo:bar")})
+	rev := p.Revision()
+	p.Delete([]string{"1"})
+	p.Delete([]string{"2"})
+
+	if h := p.History("1"); len(h) != 0 {
+		t.Fatalf("expected the history of 1 to be dropped; got %v", h)
+	}
+	if h := p.History("2"); len(h) != 2 {
+		t.Fatalf("expected %d versions of 2; got %v", 2, h)
+	}
+
+	if err := p.RollbackTo(rev); err != isenzo.ErrorVersionNotFound {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorVersionNotFound, err)
+	}
+	if qrys := p.Queries(); len(qrys) != 0 {
+		t.Fatalf("expected queries to be unchanged; got %v", qrys)
+	}
+}
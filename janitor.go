@@ -0,0 +1,64 @@
+package isenzo
+
+import (
+	"sort"
+	"time"
+)
+
+// janitor periodically removes expired queries until the Percolator is done.
+func (p *Percolator) janitor() {
+	defer p.background.Done()
+
+	ticker := time.NewTicker(p.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.RemoveExpired()
+
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// QueryRef identifies a query in a namespace.
+type QueryRef struct {
+	Namespace string `json:"namespace,omitempty"`
+	Id        string `json:"id"`
+}
+
+// RemoveExpired removes all expired queries in all namespaces, returning
+// them ordered by namespace and id.
+func (p *Percolator) RemoveExpired() ([]QueryRef, error) {
+	if err := p.begin(); err != nil {
+		return nil, err
+	}
+	defer p.end()
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	now := p.now()
+
+	var keys []string
+	for k, c := range p.cache {
+		if c.expired(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	refs := make([]QueryRef, 0, len(keys))
+	for _, k := range keys {
+		if err := p.delete(k); err != nil {
+			return refs, err
+		}
+
+		ns, id := splitKey(k)
+		refs = append(refs, QueryRef{Namespace: ns, Id: id})
+	}
+
+	return refs, nil
+}
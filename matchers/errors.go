@@ -0,0 +1,42 @@
+package matchers
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorQueryTimeout is returned when a query exceeds its time budget.
+var ErrorQueryTimeout = errors.New("query exceeded its time budget")
+
+// ErrorObserverSet is returned when observing a factory that already has an observer.
+var ErrorObserverSet = errors.New("factory already has an observer")
+
+// QueryError represents an error evaluating a query.
+type QueryError struct {
+	Id  string
+	Err error
+}
+
+// Error returns the error message.
+func (e *QueryError) Error() string {
+	return "query " + e.Id + ": " + e.Err.Error()
+}
+
+// PanicError represents a panic recovered while evaluating a query.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// newPanicError creates a PanicError with the stack of the current goroutine.
+// It must be called from the deferred function recovering the panic.
+func newPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
+}
+
+// Error returns the error message.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
@@ -1,22 +1,73 @@
 package matchers
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/document"
 	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/nrwiersma/isenzo/util"
 	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
 )
 
+type optionsFunc func(*IndexMatcherFactory)
+
+// WithBudget sets the time budget of a single query on the IndexMatcherFactory.
+//
+// Queries exceeding the budget are abandoned and reported as an
+// ErrorQueryTimeout rather than a match. An abandoned query runs to
+// completion in the background, after which its index is discarded.
+func WithBudget(budget time.Duration) optionsFunc {
+	return func(f *IndexMatcherFactory) {
+		f.budget = budget
+	}
+}
+
+// WithNestedPaths sets the paths of nested arrays on the IndexMatcherFactory.
+//
+// Each element of a nested array is indexed as a nested document, so a
+// NestedQuery can match conditions within the same element.
+func WithNestedPaths(paths ...string) optionsFunc {
+	return func(f *IndexMatcherFactory) {
+		f.nested = paths
+	}
+}
+
+// WithPoolLimit sets the maximum number of live indexes on the IndexMatcherFactory.
+//
+// When the limit is reached, New waits for an index to be returned if wait
+// is set, otherwise it returns an error.
+func WithPoolLimit(max int, wait bool) optionsFunc {
+	return func(f *IndexMatcherFactory) {
+		f.pool.MaxLive = max
+		f.pool.Wait = wait
+	}
+}
+
+// WithPoolIdleTimeout sets the time after which idle pooled indexes are closed
+// on the IndexMatcherFactory.
+func WithPoolIdleTimeout(timeout time.Duration) optionsFunc {
+	return func(f *IndexMatcherFactory) {
+		f.pool.IdleTimeout = timeout
+	}
+}
+
 // IndexMatcherFactory represents a factory for IndexMatcher.
 type IndexMatcherFactory struct {
-	mapping mapping.IndexMapping
-	pool    *util.Pool
+	mapping  mapping.IndexMapping
+	pool     *util.Pool
+	observer Observer
+	budget   time.Duration
+	nested   []string
+	panics   metrics.Counter
 }
 
 // NewIndexMatcherFactory creates a new IndexMatcherFactory.
-func NewIndexMatcherFactory(m mapping.IndexMapping) Factory {
+func NewIndexMatcherFactory(m mapping.IndexMapping, opts ...optionsFunc) Factory {
 	pool := util.NewPool(1024)
 	pool.New = func() interface{} {
 		i, err := bleve.NewMemOnly(m)
@@ -26,87 +77,307 @@ func NewIndexMatcherFactory(m mapping.IndexMapping) Factory {
 
 		return i
 	}
+	pool.Destroy = func(item interface{}) {
+		item.(bleve.Index).Close()
+	}
 
-	return &IndexMatcherFactory{
+	f := &IndexMatcherFactory{
 		mapping: m,
 		pool:    pool,
+		panics:  metrics.NilCounter{},
+	}
+
+	for _, o := range opts {
+		o(f)
 	}
+
+	return f
+}
+
+// Instrument sets the registry metrics are reported to.
+func (f *IndexMatcherFactory) Instrument(r metrics.Registry) {
+	f.panics = metrics.GetOrRegisterCounter(panicsMetric, r)
+	r.GetOrRegister("matcher.index.pool.hits", newFuncGauge(func() int64 {
+		return f.pool.Stats().Hits
+	}))
+	r.GetOrRegister("matcher.index.pool.misses", newFuncGauge(func() int64 {
+		return f.pool.Stats().Misses
+	}))
+	r.GetOrRegister("matcher.index.pool.evictions", newFuncGauge(func() int64 {
+		return f.pool.Stats().Evictions
+	}))
+	r.GetOrRegister("matcher.index.pool.live", newFuncGauge(func() int64 {
+		return int64(f.pool.Stats().Live)
+	}))
+}
+
+// Warmup creates up to n pooled indexes ahead of use, returning the number created.
+func (f *IndexMatcherFactory) Warmup(n int) int {
+	return f.pool.Warmup(n)
+}
+
+// Evict closes all pooled indexes idle for longer than the idle timeout,
+// returning the number closed.
+func (f *IndexMatcherFactory) Evict() int {
+	return f.pool.Evict()
+}
+
+// Close closes all pooled indexes. Indexes of unfinished matchers are closed
+// when they are finished.
+func (f *IndexMatcherFactory) Close() error {
+	f.pool.Close()
+	return nil
+}
+
+// Mapping returns the index mapping documents are mapped with.
+func (f *IndexMatcherFactory) Mapping() mapping.IndexMapping {
+	return f.mapping
+}
+
+// NestedPaths returns the paths of the nested arrays.
+func (f *IndexMatcherFactory) NestedPaths() []string {
+	return f.nested
+}
+
+// Observe sets the observer notified by created matchers.
+//
+// ErrorObserverSet is returned if the factory already has an observer.
+func (f *IndexMatcherFactory) Observe(o Observer) error {
+	if f.observer != nil {
+		return ErrorObserverSet
+	}
+
+	f.observer = o
+	return nil
 }
 
 // New creates a new query matcher.
 func (f IndexMatcherFactory) New(doc interface{}) (Matcher, error) {
 	var err error
-	i, ok := f.pool.Get().(bleve.Index)
-	if !ok {
-		return nil, errors.New("could not create index")
+	if doc, err = f.Map(doc); err != nil {
+		return nil, err
 	}
 
-	if doc, err = f.Map(doc); err != nil {
+	i, err := f.get()
+	if err != nil {
 		return nil, err
 	}
 
+	var docs []*document.Document
+	switch d := doc.(type) {
+	case *document.Document:
+		docs = []*document.Document{d}
+
+	case *NestedDocument:
+		docs = append([]*document.Document{d.Doc}, d.Nested...)
+	}
+
 	index, _, err := i.Advanced()
 	if err != nil {
+		f.pool.Discard(i)
 		return nil, err
 	}
 
-	if err := index.Update(doc.(*document.Document)); err != nil {
-		return nil, err
+	ids := make([]string, len(docs))
+	for j, d := range docs {
+		ids[j] = d.ID
+		if err := index.Update(d); err != nil {
+			f.pool.Discard(i)
+			return nil, err
+		}
 	}
 
 	return &IndexMatcher{
-		index: i,
+		index:  i,
+		doc:    doc,
+		nested: len(docs) > 1,
 		closing: func() {
-			f.pool.Put(i)
+			f.put(i, ids)
 		},
-		ids:  make([]string, 0),
-		errs: make([]error, 0),
+		discard: func() {
+			f.pool.Discard(i)
+		},
+		observer: f.observer,
+		budget:   f.budget,
+		panics:   f.panics,
+		ids:      make([]string, 0),
+		errs:     make([]error, 0),
 	}, nil
 }
 
+// get returns an empty index from the pool, closing any unusable indexes.
+func (f IndexMatcherFactory) get() (bleve.Index, error) {
+	for {
+		i, ok := f.pool.Get().(bleve.Index)
+		if !ok {
+			return nil, errors.New("could not create index")
+		}
+
+		if count, err := i.DocCount(); err == nil && count == 0 {
+			return i, nil
+		}
+
+		f.pool.Discard(i)
+	}
+}
+
+// put resets the index by deleting the documents and returns it to the pool.
+func (f IndexMatcherFactory) put(i bleve.Index, ids []string) {
+	for _, id := range ids {
+		if err := i.Delete(id); err != nil {
+			f.pool.Discard(i)
+			return
+		}
+	}
+
+	f.pool.Put(i)
+}
+
 // Map maps a document for the matcher.
 func (f IndexMatcherFactory) Map(doc interface{}) (interface{}, error) {
-	if _, ok := doc.(*document.Document); ok {
+	switch doc.(type) {
+	case *document.Document, *NestedDocument:
 		return doc, nil
 	}
 
-	d := document.NewDocument("doc")
+	d := document.NewDocument(DocumentID)
 	if err := f.mapping.MapDocument(d, doc); err != nil {
 		return nil, err
 	}
 
-	return d, nil
+	if len(f.nested) == 0 {
+		return d, nil
+	}
+
+	nested, err := mapNested(f.mapping, doc, f.nested)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NestedDocument{Doc: d, Nested: nested}, nil
 }
 
 // IndexMatcher represents a bleve index matcher.
 type IndexMatcher struct {
-	index bleve.Index
+	index  bleve.Index
+	doc    interface{}
+	nested bool
 
-	closing func()
+	closing  func()
+	discard  func()
+	observer Observer
+	budget   time.Duration
+	panics   metrics.Counter
+
+	running   sync.WaitGroup
+	abandoned bool
 
 	ids  []string
 	errs []error
 }
 
+// searchResult is the outcome of a search run against the matcher index.
+type searchResult struct {
+	result *bleve.SearchResult
+	err    error
+	panic  *PanicError
+}
+
 // Match matches a query with the matcher.
+//
+// A panic while evaluating the query is recovered and reported as a
+// QueryError wrapping a PanicError.
 func (m *IndexMatcher) Match(id string, q query.Query) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err := newPanicError(r)
+			m.panics.Inc(1)
+			m.errs = append(m.errs, &QueryError{Id: id, Err: err})
+
+			if m.observer != nil {
+				m.observer(m.doc, id, time.Since(start), false, err)
+			}
+		}
+	}()
+
+	if m.nested {
+		// Only the document itself can match, not its nested documents.
+		q = query.NewConjunctionQuery([]query.Query{query.NewDocIDQuery([]string{DocumentID}), q})
+	}
+
 	req := bleve.NewSearchRequest(q)
-	result, err := m.index.Search(req)
+
+	var res searchResult
+	if m.budget > 0 {
+		res = m.searchWithBudget(req)
+	} else {
+		res.result, res.err = m.index.Search(req)
+	}
+	took := time.Since(start)
+
+	err := res.err
+	if res.panic != nil {
+		m.panics.Inc(1)
+		err = res.panic
+	}
+	matched := err == nil && res.result.Total >= 1
+
+	if m.observer != nil {
+		m.observer(m.doc, id, took, matched, err)
+	}
+
 	if err != nil {
-		m.errs = append(m.errs, err)
+		m.errs = append(m.errs, &QueryError{Id: id, Err: err})
 		return
 	}
 
-	if result.Total >= 1 {
+	if matched {
 		m.ids = append(m.ids, id)
 	}
 }
 
+// searchWithBudget runs the search, giving up once the budget is exceeded.
+//
+// The search runs on its own goroutine, as building the searcher, e.g.
+// expanding a regexp or wildcard, does not check the context.
+func (m *IndexMatcher) searchWithBudget(req *bleve.SearchRequest) searchResult {
+	ctx, cancel := context.WithTimeout(context.Background(), m.budget)
+	defer cancel()
+
+	done := make(chan searchResult, 1)
+	m.running.Add(1)
+	go func() {
+		defer m.running.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				done <- searchResult{panic: newPanicError(r)}
+			}
+		}()
+
+		result, err := m.index.SearchInContext(ctx, req)
+		done <- searchResult{result: result, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res
+
+	case <-ctx.Done():
+		m.abandoned = true
+		return searchResult{err: ErrorQueryTimeout}
+	}
+}
+
 // Finish closes the matcher and returns the match results.
 func (m *IndexMatcher) Finish() (ids []string, errs []error) {
-	m.index.Close()
-
-	if m.closing != nil {
+	if m.abandoned && m.discard != nil {
+		// Abandoned searches still use the index, so it cannot be reused.
+		go func() {
+			m.running.Wait()
+			m.discard()
+		}()
+	} else if m.closing != nil {
 		m.closing()
 	}
 
@@ -1,11 +1,16 @@
 package matchers_test
 
 import (
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/blevesearch/bleve/index"
 	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/nrwiersma/isenzo/matchers"
+	"github.com/rcrowley/go-metrics"
 )
 
 func TestIndexMatcher(t *testing.T) {
@@ -51,3 +56,213 @@ func TestIndexMatcher_WithErrors(t *testing.T) {
 		t.Fatal("expected errors; got none")
 	}
 }
+
+func TestIndexMatcher_Observe(t *testing.T) {
+	var mu sync.Mutex
+	observed := map[string]bool{}
+
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping())
+	err := f.(matchers.Observable).Observe(func(doc interface{}, id string, took time.Duration, matched bool, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		observed[id] = matched
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	m, err := f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	m.Match("1", query.NewQueryStringQuery("foo:bar"))
+	m.Match("2", query.NewQueryStringQuery("test"))
+	m.Finish()
+
+	if len(observed) != 2 || !observed["1"] || observed["2"] {
+		t.Fatalf("expected observed map[1:true 2:false]; got %v", observed)
+	}
+
+	err = f.(matchers.Observable).Observe(func(doc interface{}, id string, took time.Duration, matched bool, err error) {})
+	if err != matchers.ErrorObserverSet {
+		t.Fatalf("expected %v; got %v", matchers.ErrorObserverSet, err)
+	}
+}
+
+func TestIndexMatcher_WithBudget(t *testing.T) {
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping(), matchers.WithBudget(10*time.Millisecond))
+	m, err := f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	slow := slowQuery{release: make(chan struct{})}
+	defer close(slow.release)
+
+	m.Match("1", slow)
+	m.Match("2", query.NewQueryStringQuery("foo:bar"))
+
+	ids, errs := m.Finish()
+	if len(ids) != 1 || ids[0] != "2" {
+		t.Fatalf("expected results [2]; got %v", ids)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected %d errors; got %v", 1, errs)
+	}
+
+	if qerr, ok := errs[0].(*matchers.QueryError); !ok || qerr.Id != "1" || qerr.Err != matchers.ErrorQueryTimeout {
+		t.Fatalf("expected timeout error for query 1; got %v", errs[0])
+	}
+}
+
+func TestIndexMatcher_WithBudgetPanic(t *testing.T) {
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping(), matchers.WithBudget(time.Minute))
+	m, err := f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	m.Match("1", panicQuery{})
+
+	_, errs := m.Finish()
+	if len(errs) != 1 {
+		t.Fatalf("expected %d errors; got %v", 1, errs)
+	}
+
+	if qerr, ok := errs[0].(*matchers.QueryError); !ok || qerr.Id != "1" {
+		t.Fatalf("expected error for query 1; got %v", errs[0])
+	} else if _, ok := qerr.Err.(*matchers.PanicError); !ok {
+		t.Fatalf("expected panic error; got %v", qerr.Err)
+	}
+}
+
+func TestIndexMatcher_ReusesIndex(t *testing.T) {
+	r := metrics.NewRegistry()
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping())
+	f.(matchers.Instrumenter).Instrument(r)
+
+	docs := []struct {
+		Doc  map[string]interface{}
+		Want int
+	}{
+		{map[string]interface{}{"foo": "bar"}, 1},
+		{map[string]interface{}{"foo": "baz"}, 0},
+		{map[string]interface{}{"foo": "bar"}, 1},
+	}
+	for i, d := range docs {
+		m, err := f.New(d.Doc)
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		m.Match("1", query.NewQueryStringQuery("foo:bar"))
+
+		ids, errs := m.Finish()
+		if len(errs) != 0 {
+			t.Fatalf("%d: expected no errors; got %v", i, errs)
+		}
+
+		if len(ids) != d.Want {
+			t.Fatalf("%d: expected %d results; got %v", i, d.Want, len(ids))
+		}
+	}
+
+	if hits := r.Get("matcher.index.pool.hits").(metrics.Gauge).Value(); hits != 2 {
+		t.Fatalf("expected %d pool hits; got %d", 2, hits)
+	}
+}
+
+func TestIndexMatcher_WithPoolLimit(t *testing.T) {
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping(), matchers.WithPoolLimit(1, false))
+
+	m, err := f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := f.New(map[string]interface{}{"foo": "bar"}); err == nil {
+		t.Fatal("expected errors; got none")
+	}
+
+	m.Finish()
+
+	m, err = f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	m.Finish()
+}
+
+func TestIndexMatcherFactory_Warmup(t *testing.T) {
+	r := metrics.NewRegistry()
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping())
+	f.(matchers.Instrumenter).Instrument(r)
+
+	if n := f.(*matchers.IndexMatcherFactory).Warmup(2); n != 2 {
+		t.Fatalf("expected %d indexes created; got %d", 2, n)
+	}
+
+	m, err := f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	m.Finish()
+
+	if misses := r.Get("matcher.index.pool.misses").(metrics.Gauge).Value(); misses != 0 {
+		t.Fatalf("expected %d pool misses; got %d", 0, misses)
+	}
+}
+
+func TestIndexMatcher_RecoversPanic(t *testing.T) {
+	r := metrics.NewRegistry()
+	f := matchers.NewIndexMatcherFactory(mapping.NewIndexMapping())
+	f.(matchers.Instrumenter).Instrument(r)
+	m, err := f.New(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	m.Match("1", panicQuery{})
+	m.Match("2", query.NewQueryStringQuery("foo:bar"))
+
+	ids, errs := m.Finish()
+	if len(ids) != 1 || ids[0] != "2" {
+		t.Fatalf("expected results [2]; got %v", ids)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected %d errors; got %v", 1, errs)
+	}
+
+	qerr, ok := errs[0].(*matchers.QueryError)
+	if !ok || qerr.Id != "1" {
+		t.Fatalf("expected error for query 1; got %v", errs[0])
+	}
+
+	if perr, ok := qerr.Err.(*matchers.PanicError); !ok || perr.Value != "boom" || len(perr.Stack) == 0 {
+		t.Fatalf("expected panic error with stack; got %v", qerr.Err)
+	}
+
+	if c := metrics.GetOrRegisterCounter("matcher.panics", r).Count(); c != 1 {
+		t.Fatalf("expected %d panics; got %d", 1, c)
+	}
+}
+
+type panicQuery struct{}
+
+func (q panicQuery) Searcher(i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	panic("boom")
+}
+
+// slowQuery blocks building its searcher until released.
+type slowQuery struct {
+	release chan struct{}
+}
+
+func (q slowQuery) Searcher(i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	<-q.release
+	return query.NewMatchAllQuery().Searcher(i, m, options)
+}
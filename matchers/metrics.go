@@ -0,0 +1,38 @@
+package matchers
+
+import "github.com/rcrowley/go-metrics"
+
+// Instrumenter represents a factory that can report metrics.
+type Instrumenter interface {
+	// Instrument sets the registry metrics are reported to.
+	Instrument(r metrics.Registry)
+}
+
+// panicsMetric is the name of the counter of recovered query panics.
+const panicsMetric = "matcher.panics"
+
+// funcGauge is a metrics.Gauge that reads its value from a function.
+//
+// It is a struct rather than a func type, as metrics.Registry.GetOrRegister
+// calls func values to construct the metric.
+type funcGauge struct {
+	fn func() int64
+}
+
+// newFuncGauge creates a gauge reading its value from fn.
+func newFuncGauge(fn func() int64) *funcGauge {
+	return &funcGauge{fn: fn}
+}
+
+// Snapshot returns a read-only copy of the gauge.
+func (g *funcGauge) Snapshot() metrics.Gauge {
+	return metrics.GaugeSnapshot(g.fn())
+}
+
+// Update is a noop, the value is read from the function.
+func (g *funcGauge) Update(int64) {}
+
+// Value returns the gauge's current value.
+func (g *funcGauge) Value() int64 {
+	return g.fn()
+}
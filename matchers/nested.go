@@ -0,0 +1,138 @@
+package matchers
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/blevesearch/bleve/search/searcher"
+)
+
+const (
+	// DocumentID is the id of the document indexed by the IndexMatcher.
+	DocumentID = "doc"
+
+	// NestedField is the field holding the path of a nested document.
+	NestedField = "_nested"
+)
+
+// NestedDocument represents a mapped document with a nested document for
+// each element of its nested arrays.
+type NestedDocument struct {
+	Doc    *document.Document
+	Nested []*document.Document
+}
+
+// mapNested maps the elements of the nested paths of a document into nested documents.
+func mapNested(m mapping.IndexMapping, doc interface{}, paths []string) ([]*document.Document, error) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		// Convert structs into their JSON representation to walk the paths.
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return nil, err
+		}
+	}
+
+	var nested []*document.Document
+	for _, path := range paths {
+		elems, ok := lookupPath(obj, path).([]interface{})
+		if !ok {
+			continue
+		}
+
+		for i, elem := range elems {
+			d := document.NewDocument(DocumentID + "#" + path + "#" + strconv.Itoa(i))
+			if err := m.MapDocument(d, nestPath(path, elem)); err != nil {
+				return nil, err
+			}
+			d.AddField(document.NewTextFieldWithIndexingOptions(NestedField, nil, []byte(path), document.IndexField))
+
+			nested = append(nested, d)
+		}
+	}
+
+	return nested, nil
+}
+
+// lookupPath returns the value at the dot separated path in the object.
+func lookupPath(obj map[string]interface{}, path string) interface{} {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := obj[part].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		obj = next
+	}
+
+	return obj[parts[len(parts)-1]]
+}
+
+// nestPath wraps a value in objects along the dot separated path, so it maps
+// to the same field names as in the original document.
+func nestPath(path string, v interface{}) map[string]interface{} {
+	parts := strings.Split(path, ".")
+	obj := map[string]interface{}{parts[len(parts)-1]: v}
+	for i := len(parts) - 2; i >= 0; i-- {
+		obj = map[string]interface{}{parts[i]: obj}
+	}
+
+	return obj
+}
+
+// NestedQuery matches a document if its query matches a single element of
+// the nested array at the path.
+//
+// Presearchers can extract terms from the Query, as the document holds all
+// terms of its nested elements.
+type NestedQuery struct {
+	Path  string      `json:"path"`
+	Query query.Query `json:"query"`
+}
+
+// NewNestedQuery creates a new NestedQuery.
+func NewNestedQuery(path string, q query.Query) *NestedQuery {
+	return &NestedQuery{
+		Path:  path,
+		Query: q,
+	}
+}
+
+// Searcher returns a searcher matching the document if a nested document
+// at the path matches the query.
+func (q *NestedQuery) Searcher(i index.IndexReader, m mapping.IndexMapping, options search.SearcherOptions) (search.Searcher, error) {
+	pathQuery := query.NewTermQuery(q.Path)
+	pathQuery.SetField(NestedField)
+
+	s, err := query.NewConjunctionQuery([]query.Query{pathQuery, q.Query}).Searcher(i, m, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := &search.SearchContext{
+		DocumentMatchPool: search.NewDocumentMatchPool(s.DocumentMatchPoolSize(), 0),
+	}
+	match, err := s.Next(ctx)
+	if cerr := s.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if match == nil {
+		return searcher.NewMatchNoneSearcher(i)
+	}
+
+	return searcher.NewDocIDSearcher(i, []string{DocumentID}, 1.0, options)
+}
@@ -1,11 +1,30 @@
 package matchers
 
 import (
+	"io"
+	"runtime"
 	"sync"
 
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/util"
+	"github.com/rcrowley/go-metrics"
 )
 
+var (
+	defaultWorkersOnce sync.Once
+	defaultWorkers     *util.WorkerPool
+)
+
+// DefaultWorkerPool returns the process wide worker pool, with a worker per CPU.
+func DefaultWorkerPool() *util.WorkerPool {
+	defaultWorkersOnce.Do(func() {
+		defaultWorkers = util.NewWorkerPool(runtime.GOMAXPROCS(0))
+	})
+
+	return defaultWorkers
+}
+
 type task struct {
 	Id    string
 	Query query.Query
@@ -14,45 +33,125 @@ type task struct {
 // ParallelMatcherFactory represents a factory for ParallelMatcher.
 type ParallelMatcherFactory struct {
 	factory Factory
+	pool    *util.WorkerPool
 	threads int
+
+	queue  metrics.Histogram
+	panics metrics.Counter
 }
 
-// NewParallelMatcherFactory creates a new ParallelMatcherFactory.
+// NewParallelMatcherFactory creates a new ParallelMatcherFactory running
+// queries on the DefaultWorkerPool.
 func NewParallelMatcherFactory(factory Factory, threads int) Factory {
+	return NewParallelMatcherFactoryWithPool(factory, DefaultWorkerPool(), threads)
+}
+
+// NewParallelMatcherFactoryWithPool creates a new ParallelMatcherFactory
+// running queries on the given worker pool.
+//
+// The worker pool limits the number of queries run at once across all
+// documents, while threads limits the number run at once for a single document.
+func NewParallelMatcherFactoryWithPool(factory Factory, pool *util.WorkerPool, threads int) Factory {
 	return &ParallelMatcherFactory{
 		factory: factory,
+		pool:    pool,
 		threads: threads,
+		queue:   metrics.NilHistogram{},
+		panics:  metrics.NilCounter{},
 	}
 }
 
-// New creates a new query matcher.
-func (f ParallelMatcherFactory) New(doc interface{}) (Matcher, error) {
-	m := &ParallelMatcher{
-		factory:  f.factory,
-		matchers: make([]Matcher, f.threads),
-		taskCh:   make(chan task, 1024),
+// Instrument sets the registry metrics are reported to.
+func (f *ParallelMatcherFactory) Instrument(r metrics.Registry) {
+	f.queue = metrics.GetOrRegisterHistogram("matcher.parallel.queue", r, metrics.NewExpDecaySample(1028, 0.015))
+	f.panics = metrics.GetOrRegisterCounter(panicsMetric, r)
+	r.GetOrRegister("matcher.parallel.pending", newFuncGauge(func() int64 {
+		return int64(f.pool.Stats().Pending)
+	}))
+	r.GetOrRegister("matcher.parallel.running", newFuncGauge(func() int64 {
+		return int64(f.pool.Stats().Running)
+	}))
+	r.GetOrRegister("matcher.parallel.rejected", newFuncGauge(func() int64 {
+		return f.pool.Stats().Rejected
+	}))
+
+	if i, ok := f.factory.(Instrumenter); ok {
+		i.Instrument(r)
+	}
+}
+
+// Observe sets the observer notified by created matchers.
+func (f *ParallelMatcherFactory) Observe(o Observer) error {
+	if obs, ok := f.factory.(Observable); ok {
+		return obs.Observe(o)
+	}
+
+	return nil
+}
+
+// Mapping returns the index mapping of the inner factory, if known.
+func (f *ParallelMatcherFactory) Mapping() mapping.IndexMapping {
+	if m, ok := f.factory.(Mapper); ok {
+		return m.Mapping()
+	}
+
+	return nil
+}
+
+// NestedPaths returns the paths of the nested arrays of the inner factory.
+func (f *ParallelMatcherFactory) NestedPaths() []string {
+	if n, ok := f.factory.(Nester); ok {
+		return n.NestedPaths()
+	}
+
+	return nil
+}
+
+// Forget discards the state the inner factory keeps for the query ids.
+func (f *ParallelMatcherFactory) Forget(ids ...string) {
+	if fg, ok := f.factory.(Forgetter); ok {
+		fg.Forget(ids...)
 	}
+}
 
+// Close closes the inner factory if it holds resources.
+//
+// The worker pool is not closed, as it may be shared.
+func (f *ParallelMatcherFactory) Close() error {
+	if c, ok := f.factory.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// New creates a new query matcher.
+//
+// Inner matchers are created as the document's queries are run, up to one
+// per thread.
+func (f ParallelMatcherFactory) New(doc interface{}) (Matcher, error) {
 	var err error
 	if doc, err = f.Map(doc); err != nil {
 		return nil, err
 	}
 
-	for i := 0; i < f.threads; i++ {
-		m.matchers[i], err = f.factory.New(doc)
-		if err != nil {
-			return nil, err
-		}
-
-		m.wg.Add(1)
-		go func(matcher Matcher) {
-			defer m.wg.Done()
+	matcher, err := f.factory.New(doc)
+	if err != nil {
+		return nil, err
+	}
 
-			for t := range m.taskCh {
-				matcher.Match(t.Id, t.Query)
-			}
-		}(m.matchers[i])
+	m := &ParallelMatcher{
+		factory:  f.factory,
+		doc:      doc,
+		group:    f.pool.Group(f.threads),
+		queue:    f.queue,
+		panics:   f.panics,
+		matchers: []Matcher{matcher},
+		idle:     []Matcher{matcher},
+		usable:   1,
+		errs:     make([]error, 0),
 	}
+	m.cond = sync.NewCond(&m.mu)
 
 	return m, nil
 }
@@ -64,31 +163,129 @@ func (f ParallelMatcherFactory) Map(doc interface{}) (interface{}, error) {
 
 // ParallelMatcher represents a threaded matcher.
 type ParallelMatcher struct {
-	factory  Factory
-	matchers []Matcher
+	factory Factory
+	doc     interface{}
 
-	taskCh chan task
+	group  *util.WorkGroup
+	queue  metrics.Histogram
+	panics metrics.Counter
 
-	wg sync.WaitGroup
+	mu       sync.Mutex
+	cond     *sync.Cond
+	matchers []Matcher
+	idle     []Matcher
+	usable   int
+	failed   bool
+	errs     []error
 }
 
 // Match matches a query with the matcher.
+//
+// If the worker pool is full, the query is not run and the backpressure
+// is reported as a QueryError.
 func (m *ParallelMatcher) Match(id string, q query.Query) {
-	m.taskCh <- task{
-		Id:    id,
-		Query: q,
+	m.queue.Update(int64(m.group.Pending()))
+
+	if err := m.group.Submit(func() { m.match(id, q) }); err != nil {
+		m.error(id, err)
+	}
+}
+
+// match runs a query on an idle inner matcher.
+//
+// If the inner matcher panics, the panic is reported as a QueryError and the
+// matcher is not reused.
+func (m *ParallelMatcher) match(id string, q query.Query) {
+	matcher, err := m.acquire()
+	if err != nil {
+		m.error(id, err)
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			m.panics.Inc(1)
+			m.error(id, newPanicError(r))
+
+			m.mu.Lock()
+			m.usable--
+			m.cond.Broadcast()
+			m.mu.Unlock()
+		}
+	}()
+
+	matcher.Match(id, q)
+
+	m.mu.Lock()
+	m.idle = append(m.idle, matcher)
+	m.cond.Signal()
+	m.mu.Unlock()
+}
+
+// acquire returns an idle inner matcher, creating one if none are idle.
+//
+// Once creating an inner matcher has failed, acquire waits for a usable
+// matcher to become idle instead, only failing if there are none.
+func (m *ParallelMatcher) acquire() (Matcher, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		if n := len(m.idle); n > 0 {
+			matcher := m.idle[n-1]
+			m.idle = m.idle[:n-1]
+			return matcher, nil
+		}
+
+		if m.failed && m.usable > 0 {
+			m.cond.Wait()
+			continue
+		}
+
+		matcher, err := m.newMatcher()
+		if err == nil {
+			m.matchers = append(m.matchers, matcher)
+			m.usable++
+			return matcher, nil
+		}
+
+		if m.usable == 0 {
+			return nil, err
+		}
+		m.failed = true
 	}
 }
 
+// newMatcher creates an inner matcher with the lock released, recovering a
+// panic as a PanicError. The lock must be held, and is held again on return.
+func (m *ParallelMatcher) newMatcher() (matcher Matcher, err error) {
+	m.mu.Unlock()
+	defer func() {
+		if r := recover(); r != nil {
+			m.panics.Inc(1)
+			matcher, err = nil, newPanicError(r)
+		}
+		m.mu.Lock()
+	}()
+
+	return m.factory.New(m.doc)
+}
+
+// error records an error for the query.
+func (m *ParallelMatcher) error(id string, err error) {
+	m.mu.Lock()
+	m.errs = append(m.errs, &QueryError{Id: id, Err: err})
+	m.mu.Unlock()
+}
+
 // Finish closes the matcher and returns the match results.
 func (m *ParallelMatcher) Finish() (ids []string, errs []error) {
-	close(m.taskCh)
-	m.wg.Wait()
+	m.group.Wait()
 
 	ids = make([]string, 0)
-	errs = make([]error, 0)
-	for _, m := range m.matchers {
-		i, e := m.Finish()
+	errs = m.errs
+	for _, matcher := range m.matchers {
+		i, e := matcher.Finish()
 		ids = append(ids, i...)
 		errs = append(errs, e...)
 	}
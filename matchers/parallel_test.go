@@ -1,12 +1,16 @@
 package matchers_test
 
 import (
+	"errors"
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/blevesearch/bleve"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/nrwiersma/isenzo/matchers"
+	"github.com/nrwiersma/isenzo/util"
 )
 
 func TestParallelMatcher(t *testing.T) {
@@ -30,6 +34,270 @@ func TestParallelMatcher(t *testing.T) {
 	}
 }
 
+func TestParallelMatcher_ThreadLimit(t *testing.T) {
+	pool := util.NewWorkerPool(8)
+	defer pool.Close()
+
+	inner := &countingMatcherFactory{Factory: newWaitMatcherFactory()}
+	f := matchers.NewParallelMatcherFactoryWithPool(inner, pool, 2)
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		m.Match("", bleve.NewMatchAllQuery())
+	}
+
+	ids, _ := m.Finish()
+	if len(ids) != 10 {
+		t.Fatalf("expected %d results; got %v", 10, len(ids))
+	}
+
+	if n := atomic.LoadInt64(&inner.created); n > 2 {
+		t.Fatalf("expected at most %d inner matchers; got %d", 2, n)
+	}
+}
+
+func TestParallelMatcher_Backpressure(t *testing.T) {
+	pool := util.NewWorkerPool(1)
+	pool.MaxPending = 1
+	defer pool.Close()
+
+	f := matchers.NewParallelMatcherFactoryWithPool(newWaitMatcherFactory(), pool, 1)
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		m.Match("", bleve.NewMatchAllQuery())
+	}
+
+	ids, errs := m.Finish()
+	if len(errs) == 0 {
+		t.Fatal("expected errors; got none")
+	}
+
+	for _, err := range errs {
+		qerr, ok := err.(*matchers.QueryError)
+		if !ok || qerr.Err != util.ErrorWorkerPoolFull {
+			t.Fatalf("expected %v; got %v", util.ErrorWorkerPoolFull, err)
+		}
+	}
+
+	if len(ids)+len(errs) != 5 {
+		t.Fatalf("expected %d results and errors; got %d", 5, len(ids)+len(errs))
+	}
+}
+
+func TestParallelMatcher_RecoversPanic(t *testing.T) {
+	f := matchers.NewParallelMatcherFactory(&panicMatcherFactory{}, 2)
+	testMatcherRecoversPanic(t, f)
+}
+
+func testMatcherRecoversPanic(t *testing.T, f matchers.Factory) {
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	m.Match("1", bleve.NewMatchAllQuery())
+	m.Match("panic", bleve.NewMatchAllQuery())
+	m.Match("2", bleve.NewMatchAllQuery())
+
+	ids, errs := m.Finish()
+	if len(ids) != 2 {
+		t.Fatalf("expected %d results; got %v", 2, ids)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected %d errors; got %v", 1, errs)
+	}
+
+	qerr, ok := errs[0].(*matchers.QueryError)
+	if !ok || qerr.Id != "panic" {
+		t.Fatalf("expected error for query panic; got %v", errs[0])
+	}
+
+	if _, ok := qerr.Err.(*matchers.PanicError); !ok {
+		t.Fatalf("expected panic error; got %v", qerr.Err)
+	}
+}
+
+type panicMatcherFactory struct{}
+
+func (f panicMatcherFactory) New(doc interface{}) (matchers.Matcher, error) {
+	return &panicMatcher{}, nil
+}
+
+func (f panicMatcherFactory) Map(doc interface{}) (interface{}, error) {
+	return doc, nil
+}
+
+type panicMatcher struct {
+	ids []string
+}
+
+func (m *panicMatcher) Match(id string, q query.Query) {
+	if id == "panic" {
+		panic("boom")
+	}
+
+	m.ids = append(m.ids, id)
+}
+
+func (m *panicMatcher) Finish() (ids []string, errs []error) {
+	return m.ids, []error{}
+}
+
+func TestParallelMatcherFactory_NewFails(t *testing.T) {
+	pool := util.NewWorkerPool(4)
+	defer pool.Close()
+
+	before := runtime.NumGoroutine()
+	inner := &failingMatcherFactory{failAt: 1}
+	f := matchers.NewParallelMatcherFactoryWithPool(inner, pool, 4)
+	if _, err := f.New(map[string]interface{}{}); err == nil {
+		t.Fatal("expected error; got nil")
+	}
+
+	inner.check(t)
+	checkGoroutines(t, before)
+}
+
+func TestParallelMatcher_InnerNewFails(t *testing.T) {
+	pool := util.NewWorkerPool(4)
+	defer pool.Close()
+
+	before := runtime.NumGoroutine()
+	inner := &failingMatcherFactory{failAt: 3, wait: 10 * time.Millisecond}
+	f := matchers.NewParallelMatcherFactoryWithPool(inner, pool, 4)
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		m.Match("", bleve.NewMatchAllQuery())
+	}
+
+	ids, errs := m.Finish()
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors; got %v", errs)
+	}
+
+	if len(ids) != 10 {
+		t.Fatalf("expected %d results; got %v", 10, len(ids))
+	}
+
+	inner.check(t)
+	checkGoroutines(t, before)
+}
+
+func TestParallelMatcher_InnerNewPanics(t *testing.T) {
+	pool := util.NewWorkerPool(4)
+	defer pool.Close()
+
+	inner := &failingMatcherFactory{failAt: 2, panics: true, wait: 10 * time.Millisecond}
+	f := matchers.NewParallelMatcherFactoryWithPool(inner, pool, 4)
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		m.Match("", bleve.NewMatchAllQuery())
+	}
+
+	ids, errs := m.Finish()
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors; got %v", errs)
+	}
+
+	if len(ids) != 10 {
+		t.Fatalf("expected %d results; got %v", 10, len(ids))
+	}
+
+	inner.check(t)
+}
+
+// checkGoroutines fails the test if the number of goroutines does not return to n.
+func checkGoroutines(t *testing.T, n int) {
+	for i := 0; i < 100; i++ {
+		if runtime.NumGoroutine() <= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected at most %d goroutines; got %d", n, runtime.NumGoroutine())
+}
+
+// failingMatcherFactory fails, or panics, from the failAt-th matcher created,
+// tracking the created matchers that are finished.
+type failingMatcherFactory struct {
+	failAt int64
+	panics bool
+	wait   time.Duration
+
+	created  int64
+	finished int64
+}
+
+func (f *failingMatcherFactory) New(doc interface{}) (matchers.Matcher, error) {
+	if atomic.AddInt64(&f.created, 1) >= f.failAt {
+		atomic.AddInt64(&f.created, -1)
+		if f.panics {
+			panic("test panic")
+		}
+		return nil, errors.New("test error")
+	}
+
+	return &trackedMatcher{factory: f}, nil
+}
+
+func (f *failingMatcherFactory) Map(doc interface{}) (interface{}, error) {
+	return doc, nil
+}
+
+// check fails the test if a created matcher was not finished.
+func (f *failingMatcherFactory) check(t *testing.T) {
+	created, finished := atomic.LoadInt64(&f.created), atomic.LoadInt64(&f.finished)
+	if created != finished {
+		t.Fatalf("expected %d created matchers to be finished; got %d", created, finished)
+	}
+}
+
+type trackedMatcher struct {
+	factory *failingMatcherFactory
+	ids     []string
+}
+
+func (m *trackedMatcher) Match(id string, q query.Query) {
+	time.Sleep(m.factory.wait)
+
+	m.ids = append(m.ids, id)
+}
+
+func (m *trackedMatcher) Finish() (ids []string, errs []error) {
+	atomic.AddInt64(&m.factory.finished, 1)
+
+	return m.ids, []error{}
+}
+
+type countingMatcherFactory struct {
+	matchers.Factory
+
+	created int64
+}
+
+func (f *countingMatcherFactory) New(doc interface{}) (matchers.Matcher, error) {
+	atomic.AddInt64(&f.created, 1)
+
+	return f.Factory.New(doc)
+}
+
 type waitMatcherFactory struct {}
 
 func newWaitMatcherFactory() matchers.Factory {
@@ -0,0 +1,445 @@
+package matchers
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/util"
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// costWeight is the weight of the latest evaluation in the historical query cost.
+	costWeight = 0.2
+
+	// replanInterval is the minimum interval between partition plans.
+	replanInterval = time.Second
+)
+
+type evaluation struct {
+	id   string
+	took time.Duration
+}
+
+// queryCost is the historical evaluation cost of a query, updated atomically.
+type queryCost struct {
+	cost int64
+}
+
+// load returns the cost.
+func (c *queryCost) load() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.cost))
+}
+
+// observe folds an evaluation time into the cost.
+func (c *queryCost) observe(took time.Duration) {
+	for {
+		old := atomic.LoadInt64(&c.cost)
+		cost := int64((1-costWeight)*float64(old) + costWeight*float64(took))
+		if atomic.CompareAndSwapInt64(&c.cost, old, cost) {
+			return
+		}
+	}
+}
+
+// partitionPlan assigns the queries with a known cost to shards. A plan is
+// not changed once stored, apart from the costs, which are shared between
+// plans.
+type partitionPlan struct {
+	costs  map[string]*queryCost
+	shards map[string]int
+}
+
+// partition splits the tasks into n shards, following the plan for queries
+// with a known cost and spreading the others evenly.
+func (pl *partitionPlan) partition(tasks []task, n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{tasks: make([]task, 0, len(tasks)/n+1)}
+	}
+
+	next := 0
+	for _, t := range tasks {
+		i, ok := pl.shards[t.Id]
+		if !ok || i >= n {
+			i = next % n
+			next++
+		}
+
+		shards[i].tasks = append(shards[i].tasks, t)
+	}
+
+	return shards
+}
+
+// PartitionedMatcherFactory represents a factory for PartitionedMatcher.
+type PartitionedMatcherFactory struct {
+	factory Factory
+	pool    *util.WorkerPool
+	threads int
+	panics  metrics.Counter
+
+	plan     atomic.Value
+	planning int32
+	planned  int64
+
+	mu        sync.Mutex
+	pending   map[string]time.Duration
+	forgotten map[string]bool
+}
+
+// NewPartitionedMatcherFactory creates a new PartitionedMatcherFactory
+// running shards on the DefaultWorkerPool.
+//
+// A thread count below 1 is treated as 1.
+func NewPartitionedMatcherFactory(factory Factory, threads int) Factory {
+	return NewPartitionedMatcherFactoryWithPool(factory, DefaultWorkerPool(), threads)
+}
+
+// NewPartitionedMatcherFactoryWithPool creates a new PartitionedMatcherFactory
+// running shards on the given worker pool.
+//
+// A thread count below 1 is treated as 1.
+func NewPartitionedMatcherFactoryWithPool(factory Factory, pool *util.WorkerPool, threads int) Factory {
+	if threads < 1 {
+		threads = 1
+	}
+
+	f := &PartitionedMatcherFactory{
+		factory:   factory,
+		pool:      pool,
+		threads:   threads,
+		panics:    metrics.NilCounter{},
+		pending:   map[string]time.Duration{},
+		forgotten: map[string]bool{},
+	}
+	f.plan.Store(&partitionPlan{costs: map[string]*queryCost{}, shards: map[string]int{}})
+
+	return f
+}
+
+// Instrument sets the registry metrics are reported to.
+func (f *PartitionedMatcherFactory) Instrument(r metrics.Registry) {
+	f.panics = metrics.GetOrRegisterCounter(panicsMetric, r)
+
+	if i, ok := f.factory.(Instrumenter); ok {
+		i.Instrument(r)
+	}
+}
+
+// Observe sets the observer notified by created matchers.
+func (f *PartitionedMatcherFactory) Observe(o Observer) error {
+	if obs, ok := f.factory.(Observable); ok {
+		return obs.Observe(o)
+	}
+
+	return nil
+}
+
+// Mapping returns the index mapping of the inner factory, if known.
+func (f *PartitionedMatcherFactory) Mapping() mapping.IndexMapping {
+	if m, ok := f.factory.(Mapper); ok {
+		return m.Mapping()
+	}
+
+	return nil
+}
+
+// NestedPaths returns the paths of the nested arrays of the inner factory.
+func (f *PartitionedMatcherFactory) NestedPaths() []string {
+	if n, ok := f.factory.(Nester); ok {
+		return n.NestedPaths()
+	}
+
+	return nil
+}
+
+// Forget discards the historical cost of the query ids.
+//
+// The costs are dropped from the plan when it is next computed.
+func (f *PartitionedMatcherFactory) Forget(ids ...string) {
+	f.mu.Lock()
+	for _, id := range ids {
+		delete(f.pending, id)
+		f.forgotten[id] = true
+	}
+	f.mu.Unlock()
+	atomic.StoreInt64(&f.planned, 0)
+
+	if fg, ok := f.factory.(Forgetter); ok {
+		fg.Forget(ids...)
+	}
+}
+
+// Close closes the inner factory if it holds resources.
+func (f *PartitionedMatcherFactory) Close() error {
+	if c, ok := f.factory.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// New creates a new query matcher.
+func (f *PartitionedMatcherFactory) New(doc interface{}) (Matcher, error) {
+	var err error
+	if doc, err = f.Map(doc); err != nil {
+		return nil, err
+	}
+
+	m := &PartitionedMatcher{
+		factory:  f,
+		matchers: make([]Matcher, f.threads),
+		tasks:    make([]task, 0),
+	}
+
+	for i := range m.matchers {
+		if m.matchers[i], err = f.factory.New(doc); err != nil {
+			for _, matcher := range m.matchers[:i] {
+				matcher.Finish()
+			}
+
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Map maps a document for the matcher.
+func (f *PartitionedMatcherFactory) Map(doc interface{}) (interface{}, error) {
+	return f.factory.Map(doc)
+}
+
+// loadPlan returns the current partition plan.
+func (f *PartitionedMatcherFactory) loadPlan() *partitionPlan {
+	return f.plan.Load().(*partitionPlan)
+}
+
+// record updates the historical cost of the evaluated queries, computing a
+// new plan in the background once the current plan is old enough.
+func (f *PartitionedMatcherFactory) record(plan *partitionPlan, evals []evaluation) {
+	var unknown []evaluation
+	for _, e := range evals {
+		if c, ok := plan.costs[e.id]; ok {
+			c.observe(e.took)
+			continue
+		}
+		unknown = append(unknown, e)
+	}
+
+	if len(unknown) > 0 {
+		f.mu.Lock()
+		for _, e := range unknown {
+			cost, ok := f.pending[e.id]
+			if !ok {
+				f.pending[e.id] = e.took
+				continue
+			}
+
+			f.pending[e.id] = time.Duration((1-costWeight)*float64(cost) + costWeight*float64(e.took))
+		}
+		f.mu.Unlock()
+	}
+
+	planned := atomic.LoadInt64(&f.planned)
+	if time.Since(time.Unix(0, planned)) < replanInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&f.planning, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&f.planning, 0)
+
+		f.replan()
+	}()
+}
+
+// replan computes a new plan, assigning the most expensive remaining query
+// to the least loaded shard.
+func (f *PartitionedMatcherFactory) replan() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.loadPlan()
+	costs := make(map[string]*queryCost, len(old.costs)+len(f.pending))
+	for id, c := range old.costs {
+		if !f.forgotten[id] {
+			costs[id] = c
+		}
+	}
+	for id, cost := range f.pending {
+		costs[id] = &queryCost{cost: int64(cost)}
+	}
+	f.pending = map[string]time.Duration{}
+	f.forgotten = map[string]bool{}
+
+	type entry struct {
+		id   string
+		cost time.Duration
+	}
+	entries := make([]entry, 0, len(costs))
+	for id, c := range costs {
+		entries = append(entries, entry{id: id, cost: c.load()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].cost != entries[j].cost {
+			return entries[i].cost > entries[j].cost
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	shards := make(map[string]int, len(entries))
+	loads := make([]time.Duration, f.threads)
+	for _, e := range entries {
+		min := 0
+		for i := 1; i < len(loads); i++ {
+			if loads[i] < loads[min] {
+				min = i
+			}
+		}
+
+		shards[e.id] = min
+		loads[min] += e.cost
+	}
+
+	f.plan.Store(&partitionPlan{costs: costs, shards: shards})
+	atomic.StoreInt64(&f.planned, time.Now().UnixNano())
+}
+
+// shard represents the queries assigned to a worker.
+type shard struct {
+	mu    sync.Mutex
+	tasks []task
+}
+
+// pop removes the next task from the front of the shard.
+func (s *shard) pop() (task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.tasks) == 0 {
+		return task{}, false
+	}
+
+	t := s.tasks[0]
+	s.tasks = s.tasks[1:]
+	return t, true
+}
+
+// steal removes a task from the back of the shard.
+func (s *shard) steal() (task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.tasks)
+	if n == 0 {
+		return task{}, false
+	}
+
+	t := s.tasks[n-1]
+	s.tasks = s.tasks[:n-1]
+	return t, true
+}
+
+// PartitionedMatcher represents a threaded matcher that partitions the
+// queries between its workers by historical cost, with work stealing.
+type PartitionedMatcher struct {
+	factory  *PartitionedMatcherFactory
+	matchers []Matcher
+
+	tasks []task
+}
+
+// Match matches a query with the matcher.
+//
+// Queries are evaluated when the matcher is finished.
+func (m *PartitionedMatcher) Match(id string, q query.Query) {
+	m.tasks = append(m.tasks, task{Id: id, Query: q})
+}
+
+// Finish closes the matcher and returns the match results.
+//
+// The shards are run on the worker pool. A shard the pool rejects is run by
+// the caller instead.
+func (m *PartitionedMatcher) Finish() (ids []string, errs []error) {
+	plan := m.factory.loadPlan()
+	shards := plan.partition(m.tasks, len(m.matchers))
+	evals := make([][]evaluation, len(m.matchers))
+	panics := make([][]error, len(m.matchers))
+
+	group := m.factory.pool.Group(len(m.matchers))
+	for i := range m.matchers {
+		i := i
+		fn := func() {
+			evals[i], panics[i] = m.run(i, shards)
+		}
+
+		if err := group.Submit(fn); err != nil {
+			fn()
+		}
+	}
+	group.Wait()
+
+	var all []evaluation
+	for _, e := range evals {
+		all = append(all, e...)
+	}
+	m.factory.record(plan, all)
+
+	ids = make([]string, 0)
+	errs = make([]error, 0)
+	for _, e := range panics {
+		errs = append(errs, e...)
+	}
+	for _, matcher := range m.matchers {
+		i, e := matcher.Finish()
+		ids = append(ids, i...)
+		errs = append(errs, e...)
+	}
+
+	return
+}
+
+// run evaluates the tasks of shard i, stealing from other shards once empty.
+// Recovered panics are returned as errors.
+func (m *PartitionedMatcher) run(i int, shards []*shard) (evals []evaluation, errs []error) {
+	matcher := m.matchers[i]
+	evals = make([]evaluation, 0)
+
+	for {
+		t, ok := shards[i].pop()
+		for j := 1; !ok && j < len(shards); j++ {
+			t, ok = shards[(i+j)%len(shards)].steal()
+		}
+		if !ok {
+			return evals, errs
+		}
+
+		start := time.Now()
+		if err := m.match(matcher, t); err != nil {
+			errs = append(errs, err)
+		}
+		evals = append(evals, evaluation{id: t.Id, took: time.Since(start)})
+	}
+}
+
+// match runs a query on the inner matcher, recovering any panic as a QueryError.
+func (m *PartitionedMatcher) match(matcher Matcher, t task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.factory.panics.Inc(1)
+			err = &QueryError{Id: t.Id, Err: newPanicError(r)}
+		}
+	}()
+
+	matcher.Match(t.Id, t.Query)
+	return nil
+}
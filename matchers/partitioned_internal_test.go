@@ -0,0 +1,142 @@
+package matchers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve/search/query"
+)
+
+func TestPartitionedMatcherFactory_ReplanBalancesCost(t *testing.T) {
+	f := NewPartitionedMatcherFactory(&recordingMatcherFactory{}, 2).(*PartitionedMatcherFactory)
+	f.pending = map[string]time.Duration{"a": 4, "b": 3, "c": 2, "d": 1}
+	f.replan()
+
+	// e has no history, so it is placed on the next shard in turn.
+	tasks := []task{}
+	for _, id := range []string{"d", "c", "e", "b", "a"} {
+		tasks = append(tasks, task{Id: id})
+	}
+
+	plan := f.loadPlan()
+	shards := plan.partition(tasks, 2)
+
+	if len(shards) != 2 {
+		t.Fatalf("expected %d shards; got %d", 2, len(shards))
+	}
+	for i, s := range shards {
+		var load time.Duration
+		for _, t := range s.tasks {
+			if c, ok := plan.costs[t.Id]; ok {
+				load += c.load()
+			}
+		}
+
+		if load != 5 {
+			t.Fatalf("expected shard %d load %d; got %d with %v", i, 5, load, s.tasks)
+		}
+	}
+	if len(shards[0].tasks) != 3 {
+		t.Fatalf("expected the unknown task on shard 0; got %v", shards[0].tasks)
+	}
+}
+
+func TestPartitionedMatcherFactory_RecordKnownCost(t *testing.T) {
+	f := NewPartitionedMatcherFactory(&recordingMatcherFactory{}, 2).(*PartitionedMatcherFactory)
+	f.pending = map[string]time.Duration{"a": 10}
+	f.replan()
+
+	plan := f.loadPlan()
+	f.record(plan, []evaluation{{id: "a", took: 20}, {id: "b", took: 5}})
+
+	if got := plan.costs["a"].load(); got != 12 {
+		t.Fatalf("expected cost %d; got %d", 12, got)
+	}
+	if len(f.pending) != 1 || f.pending["b"] != 5 {
+		t.Fatalf("expected b to be pending; got %v", f.pending)
+	}
+}
+
+func TestPartitionedMatcher_RunSteals(t *testing.T) {
+	f := NewPartitionedMatcherFactory(&recordingMatcherFactory{}, 2).(*PartitionedMatcherFactory)
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	pm := m.(*PartitionedMatcher)
+
+	shards := []*shard{
+		{tasks: []task{{Id: "1"}, {Id: "2"}, {Id: "3"}}},
+		{},
+	}
+
+	evals, errs := pm.run(1, shards)
+	if len(errs) != 0 {
+		t.Fatalf("expected no errors; got %v", errs)
+	}
+
+	// The idle worker steals from the back of the other shard.
+	got := pm.matchers[1].(*recordingMatcher).ids
+	if len(got) != 3 || got[0] != "3" || got[1] != "2" || got[2] != "1" {
+		t.Fatalf("expected stolen tasks [3 2 1]; got %v", got)
+	}
+	if len(evals) != 3 {
+		t.Fatalf("expected %d evaluations; got %d", 3, len(evals))
+	}
+	if len(shards[0].tasks) != 0 {
+		t.Fatalf("expected the other shard to be empty; got %v", shards[0].tasks)
+	}
+}
+
+func TestPartitionedMatcherFactory_Forget(t *testing.T) {
+	f := NewPartitionedMatcherFactory(&recordingMatcherFactory{}, 2).(*PartitionedMatcherFactory)
+	f.pending = map[string]time.Duration{"a": time.Millisecond, "b": time.Millisecond}
+	f.replan()
+
+	f.Forget("a")
+	f.replan()
+
+	plan := f.loadPlan()
+	if _, ok := plan.costs["a"]; ok {
+		t.Fatal("expected the cost of a to be forgotten")
+	}
+	if _, ok := plan.costs["b"]; !ok {
+		t.Fatal("expected the cost of b to be kept")
+	}
+}
+
+func TestNewPartitionedMatcherFactory_NoThreads(t *testing.T) {
+	f := NewPartitionedMatcherFactory(&recordingMatcherFactory{}, 0)
+
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	m.Match("1", query.NewMatchAllQuery())
+	if ids, errs := m.Finish(); len(ids) != 1 || len(errs) != 0 {
+		t.Fatalf("expected results [1]; got %v %v", ids, errs)
+	}
+}
+
+type recordingMatcherFactory struct{}
+
+func (f recordingMatcherFactory) New(doc interface{}) (Matcher, error) {
+	return &recordingMatcher{}, nil
+}
+
+func (f recordingMatcherFactory) Map(doc interface{}) (interface{}, error) {
+	return doc, nil
+}
+
+type recordingMatcher struct {
+	ids []string
+}
+
+func (m *recordingMatcher) Match(id string, q query.Query) {
+	m.ids = append(m.ids, id)
+}
+
+func (m *recordingMatcher) Finish() (ids []string, errs []error) {
+	return m.ids, []error{}
+}
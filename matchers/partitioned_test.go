@@ -0,0 +1,147 @@
+package matchers_test
+
+import (
+	"errors"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+func TestPartitionedMatcher(t *testing.T) {
+	f := matchers.NewPartitionedMatcherFactory(newWaitMatcherFactory(), 4)
+
+	// Run twice so the second run is partitioned by historical cost.
+	for run := 0; run < 2; run++ {
+		m, err := f.New(map[string]interface{}{})
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		for i := 0; i < 10; i++ {
+			m.Match(strconv.Itoa(i), bleve.NewMatchAllQuery())
+		}
+
+		ids, errs := m.Finish()
+		if len(errs) != 0 {
+			t.Fatalf("expected no errors; got %v", errs)
+		}
+
+		if len(ids) != 10 {
+			t.Fatalf("expected %d results; got %v", 10, len(ids))
+		}
+	}
+}
+
+func TestPartitionedMatcher_NoQueries(t *testing.T) {
+	f := matchers.NewPartitionedMatcherFactory(newWaitMatcherFactory(), 4)
+	m, err := f.New(map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	ids, errs := m.Finish()
+	if len(ids) != 0 || len(errs) != 0 {
+		t.Fatalf("expected no results; got %v %v", ids, errs)
+	}
+}
+
+func TestPartitionedMatcher_FactoryError(t *testing.T) {
+	f := matchers.NewPartitionedMatcherFactory(errorMatcherFactory{}, 4)
+	if _, err := f.New(map[string]interface{}{}); err == nil {
+		t.Fatal("expected error; got nil")
+	}
+}
+
+func TestPartitionedMatcherFactory_NewFails(t *testing.T) {
+	before := runtime.NumGoroutine()
+	inner := &failingMatcherFactory{failAt: 3}
+	f := matchers.NewPartitionedMatcherFactory(inner, 4)
+	if _, err := f.New(map[string]interface{}{}); err == nil {
+		t.Fatal("expected error; got nil")
+	}
+
+	inner.check(t)
+	checkGoroutines(t, before)
+}
+
+func TestPartitionedMatcher_RecoversPanic(t *testing.T) {
+	f := matchers.NewPartitionedMatcherFactory(&panicMatcherFactory{}, 2)
+	testMatcherRecoversPanic(t, f)
+}
+
+func BenchmarkParallelMatcher_1000Rules(b *testing.B) {
+	benchmarkMatcher(b, matchers.NewParallelMatcherFactory(newBenchmarkFactory(), 5), 1000)
+}
+
+func BenchmarkParallelMatcher_10000Rules(b *testing.B) {
+	benchmarkMatcher(b, matchers.NewParallelMatcherFactory(newBenchmarkFactory(), 5), 10000)
+}
+
+func BenchmarkParallelMatcher_100000Rules(b *testing.B) {
+	benchmarkMatcher(b, matchers.NewParallelMatcherFactory(newBenchmarkFactory(), 5), 100000)
+}
+
+func BenchmarkPartitionedMatcher_1000Rules(b *testing.B) {
+	benchmarkMatcher(b, matchers.NewPartitionedMatcherFactory(newBenchmarkFactory(), 5), 1000)
+}
+
+func BenchmarkPartitionedMatcher_10000Rules(b *testing.B) {
+	benchmarkMatcher(b, matchers.NewPartitionedMatcherFactory(newBenchmarkFactory(), 5), 10000)
+}
+
+func BenchmarkPartitionedMatcher_100000Rules(b *testing.B) {
+	benchmarkMatcher(b, matchers.NewPartitionedMatcherFactory(newBenchmarkFactory(), 5), 100000)
+}
+
+func newBenchmarkFactory() matchers.Factory {
+	m := bleve.NewIndexMapping()
+	m.DefaultAnalyzer = keyword.Name
+	m.StoreDynamic = false
+
+	return matchers.NewIndexMatcherFactory(m)
+}
+
+func benchmarkMatcher(b *testing.B, f matchers.Factory, n int) {
+	b.ReportAllocs()
+
+	ids := make([]string, n)
+	qrys := make([]query.Query, n)
+	for i := 0; i < n; i++ {
+		ids[i] = strconv.Itoa(i)
+
+		q := bleve.NewTermQuery("bat")
+		q.SetField("baz")
+		qrys[i] = q
+	}
+	data := map[string]interface{}{"foo": "bar"}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		m, err := f.New(data)
+		if err != nil {
+			b.Fatalf("unexpected err; got %v", err)
+		}
+
+		for j := range qrys {
+			m.Match(ids[j], qrys[j])
+		}
+
+		_, _ = m.Finish()
+	}
+}
+
+type errorMatcherFactory struct{}
+
+func (f errorMatcherFactory) New(doc interface{}) (matchers.Matcher, error) {
+	return nil, errors.New("test error")
+}
+
+func (f errorMatcherFactory) Map(doc interface{}) (interface{}, error) {
+	return doc, nil
+}
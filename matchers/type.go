@@ -1,6 +1,9 @@
 package matchers
 
 import (
+	"time"
+
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search/query"
 )
 
@@ -21,3 +24,34 @@ type Matcher interface {
 	// Finish closes the matcher and returns the match results.
 	Finish() (ids []string, errs []error)
 }
+
+// Observer is notified of every query evaluated by a matcher, with the mapped
+// document the query was evaluated against.
+//
+// Observers may be called concurrently and must be safe for concurrent use.
+type Observer func(doc interface{}, id string, took time.Duration, matched bool, err error)
+
+// Observable represents a factory whose matchers report query evaluations.
+type Observable interface {
+	// Observe sets the observer notified by created matchers. A factory has
+	// a single observer, so it cannot be shared between percolators.
+	Observe(o Observer) error
+}
+
+// Nester represents a factory that indexes the elements of nested arrays.
+type Nester interface {
+	// NestedPaths returns the paths of the nested arrays.
+	NestedPaths() []string
+}
+
+// Forgetter represents a factory keeping state per query id.
+type Forgetter interface {
+	// Forget discards the state kept for the query ids, e.g. of deleted queries.
+	Forget(ids ...string)
+}
+
+// Mapper represents a factory that maps documents with a bleve index mapping.
+type Mapper interface {
+	// Mapping returns the index mapping documents are mapped with, or nil if unknown.
+	Mapping() mapping.IndexMapping
+}
@@ -0,0 +1,63 @@
+package isenzo
+
+import "github.com/rcrowley/go-metrics"
+
+// percolatorMetrics holds the metrics reported by a Percolator.
+type percolatorMetrics struct {
+	latency    metrics.Timer
+	queries    metrics.Gauge
+	candidates metrics.Histogram
+
+	mapping   metrics.Timer
+	presearch metrics.Timer
+	build     metrics.Timer
+	execute   metrics.Timer
+
+	mappingErrors   metrics.Counter
+	presearchErrors metrics.Counter
+	buildErrors     metrics.Counter
+	executeErrors   metrics.Counter
+
+	retroactiveDropped metrics.Counter
+	corpusErrors       metrics.Counter
+}
+
+// newPercolatorMetrics creates the percolator metrics in the registry. If the
+// registry is nil, the metrics are discarded.
+func newPercolatorMetrics(r metrics.Registry) *percolatorMetrics {
+	if r == nil {
+		return &percolatorMetrics{
+			latency:         metrics.NilTimer{},
+			queries:         metrics.NilGauge{},
+			candidates:      metrics.NilHistogram{},
+			mapping:         metrics.NilTimer{},
+			presearch:       metrics.NilTimer{},
+			build:           metrics.NilTimer{},
+			execute:         metrics.NilTimer{},
+			mappingErrors:   metrics.NilCounter{},
+			presearchErrors: metrics.NilCounter{},
+			buildErrors:     metrics.NilCounter{},
+			executeErrors:   metrics.NilCounter{},
+
+			retroactiveDropped: metrics.NilCounter{},
+			corpusErrors:       metrics.NilCounter{},
+		}
+	}
+
+	return &percolatorMetrics{
+		latency:         metrics.GetOrRegisterTimer("match.latency", r),
+		queries:         metrics.GetOrRegisterGauge("match.queries", r),
+		candidates:      metrics.GetOrRegisterHistogram("match.candidates", r, metrics.NewExpDecaySample(1028, 0.015)),
+		mapping:         metrics.GetOrRegisterTimer("match.phase.mapping", r),
+		presearch:       metrics.GetOrRegisterTimer("match.phase.presearch", r),
+		build:           metrics.GetOrRegisterTimer("match.phase.build", r),
+		execute:         metrics.GetOrRegisterTimer("match.phase.execute", r),
+		mappingErrors:   metrics.GetOrRegisterCounter("match.errors.mapping", r),
+		presearchErrors: metrics.GetOrRegisterCounter("match.errors.presearch", r),
+		buildErrors:     metrics.GetOrRegisterCounter("match.errors.build", r),
+		executeErrors:   metrics.GetOrRegisterCounter("match.errors.execute", r),
+
+		retroactiveDropped: metrics.GetOrRegisterCounter("retroactive.dropped", r),
+		corpusErrors:       metrics.GetOrRegisterCounter("backtest.corpus.errors", r),
+	}
+}
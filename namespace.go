@@ -0,0 +1,261 @@
+package isenzo
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// DefaultNamespace is the namespace used by the Percolator methods.
+const DefaultNamespace = ""
+
+const namespaceField = "_namespace"
+
+var (
+	ErrorInvalidNamespace = errors.New("namespace cannot contain '/'")
+	ErrorNamespaceLimit   = errors.New("namespace query limit exceeded")
+)
+
+// key returns the cache key of a query in a namespace.
+func key(ns, id string) string {
+	return ns + "/" + id
+}
+
+// splitKey splits a cache key into its namespace and query id.
+func splitKey(k string) (ns, id string) {
+	i := strings.IndexByte(k, '/')
+	if i < 0 {
+		return DefaultNamespace, k
+	}
+
+	return k[:i], k[i+1:]
+}
+
+// newNamespaceField creates the query index field holding the namespace.
+func newNamespaceField(ns string) document.Field {
+	return document.NewTextFieldWithIndexingOptions(namespaceField, nil, []byte("ns:"+ns), document.IndexField)
+}
+
+// newNamespaceQuery creates a query matching any of the namespaces in the query index.
+func newNamespaceQuery(namespaces []string) query.Query {
+	qrys := make([]query.Query, len(namespaces))
+	for i, ns := range namespaces {
+		q := query.NewTermQuery("ns:" + ns)
+		q.SetField(namespaceField)
+		qrys[i] = q
+	}
+
+	return query.NewDisjunctionQuery(qrys)
+}
+
+// namespace holds the state of a namespace.
+type namespace struct {
+	documents int64
+	matches   int64
+
+	queries int
+	limit   int
+}
+
+// NamespaceStats represents the statistics of a namespace.
+type NamespaceStats struct {
+	Name      string `json:"name"`
+	Queries   int    `json:"queries"`
+	Limit     int    `json:"limit"`
+	Documents int64  `json:"documents"`
+	Matches   int64  `json:"matches"`
+}
+
+// namespace returns the state of a namespace, creating it if needed. The
+// cache lock must be held for writing.
+func (p *Percolator) namespace(ns string) *namespace {
+	state, ok := p.namespaces[ns]
+	if !ok {
+		state = &namespace{}
+		p.namespaces[ns] = state
+	}
+
+	return state
+}
+
+// limit returns the query limit of a namespace state.
+func (p *Percolator) limit(state *namespace) int {
+	if state.limit > 0 {
+		return state.limit
+	}
+
+	return p.namespaceLimit
+}
+
+// checkLimit determines if the queries fit within the namespace limit. The
+// cache lock must be held for writing.
+func (p *Percolator) checkLimit(ns string, qrys []Query) error {
+	state := p.namespace(ns)
+	limit := p.limit(state)
+	if limit <= 0 {
+		return nil
+	}
+
+	added := map[string]bool{}
+	for _, qry := range qrys {
+		if _, ok := p.cache[key(ns, qry.Id)]; !ok {
+			added[qry.Id] = true
+		}
+	}
+
+	if state.queries+len(added) > limit {
+		return ErrorNamespaceLimit
+	}
+
+	return nil
+}
+
+// SetNamespaceLimit sets the maximum number of queries in a namespace,
+// overriding the default limit. A limit of 0 resets it to the default.
+func (p *Percolator) SetNamespaceLimit(name string, max int) {
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	p.namespace(name).limit = max
+}
+
+// Namespaces returns the statistics of all namespaces, ordered by name.
+func (p *Percolator) Namespaces() []NamespaceStats {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	stats := make([]NamespaceStats, 0, len(p.namespaces))
+	for name, state := range p.namespaces {
+		stats = append(stats, NamespaceStats{
+			Name:      name,
+			Queries:   state.queries,
+			Limit:     p.limit(state),
+			Documents: atomic.LoadInt64(&state.documents),
+			Matches:   atomic.LoadInt64(&state.matches),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+
+	return stats
+}
+
+// Namespace represents the queries of a single tenant on a Percolator.
+//
+// All namespaces share the matchers and query index of the Percolator.
+type Namespace struct {
+	p    *Percolator
+	name string
+}
+
+// Namespace returns the namespace with the given name.
+func (p *Percolator) Namespace(name string) (*Namespace, error) {
+	if strings.Contains(name, "/") {
+		return nil, ErrorInvalidNamespace
+	}
+
+	return &Namespace{p: p, name: name}, nil
+}
+
+// Name returns the name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// Update sets the queries in the namespace.
+func (n *Namespace) Update(qrys []Query) error {
+	return n.p.update(n.name, qrys)
+}
+
+// Delete removes the queries with the given ids from the namespace.
+func (n *Namespace) Delete(ids []string) error {
+	return n.p.deleteIds(n.name, ids)
+}
+
+// Query returns the query with the given id.
+func (n *Namespace) Query(id string) (Query, bool) {
+	return n.p.query(n.name, id)
+}
+
+// Queries returns all queries in the namespace, ordered by id.
+func (n *Namespace) Queries() []Query {
+	return n.p.queries(n.name)
+}
+
+// Stats returns the statistics of the query with the given id.
+func (n *Namespace) Stats(id string) (QueryStats, bool) {
+	return n.p.stats(n.name, id)
+}
+
+// History returns the versions of the query with the given id, oldest first.
+func (n *Namespace) History(id string) []QueryVersion {
+	return n.p.queryHistory(n.name, id)
+}
+
+// Diff returns the differences between two versions of the query with the given id.
+func (n *Namespace) Diff(id string, from, to int) (*QueryDiff, error) {
+	return n.p.diff(n.name, id, from, to)
+}
+
+// Rollback restores the query with the given id to a previous version.
+func (n *Namespace) Rollback(id string, version int) error {
+	return n.p.rollback(n.name, id, version)
+}
+
+// Stage sets shadow versions of queries in the namespace.
+func (n *Namespace) Stage(qrys []Query) error {
+	return n.p.stage(n.name, qrys)
+}
+
+// Unstage removes the shadow queries with the given ids from the namespace.
+func (n *Namespace) Unstage(ids []string) error {
+	return n.p.unstage(n.name, ids)
+}
+
+// Promote replaces the live queries with the given ids by their shadow versions.
+func (n *Namespace) Promote(ids []string) error {
+	return n.p.promote(n.name, ids)
+}
+
+// ShadowReport returns the report of the shadow query with the given id.
+func (n *Namespace) ShadowReport(id string) (ShadowReport, bool) {
+	return n.p.shadowReport(n.name, id)
+}
+
+// Backtest matches a query against the documents recently matched against the namespace.
+func (n *Namespace) Backtest(qry Query) (*BacktestResult, error) {
+	return n.p.backtest(n.name, qry)
+}
+
+// CompareBacktest compares the documents recently matched against the
+// namespace that two queries match.
+func (n *Namespace) CompareBacktest(from, to Query) (*BacktestComparison, error) {
+	return n.p.compareBacktest(n.name, from, to)
+}
+
+// BacktestVersions compares the documents recently matched against the
+// namespace that two versions of the query with the given id match.
+func (n *Namespace) BacktestVersions(id string, from, to int) (*BacktestComparison, error) {
+	return n.p.backtestVersions(n.name, id, from, to)
+}
+
+// Reinstate reinstates a quarantined query.
+func (n *Namespace) Reinstate(id string) error {
+	return n.p.reinstate(n.name, id)
+}
+
+// Match matches a document against the queries in the namespace.
+func (n *Namespace) Match(doc interface{}) (*Results, error) {
+	res, err := n.p.match(doc, []string{n.name})
+	if err != nil {
+		return nil, err
+	}
+
+	return res[n.name], nil
+}
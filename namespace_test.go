@@ -0,0 +1,100 @@
+package isenzo_test
+
+import (
+	"testing"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_MatchNamespaces(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	a, _ := p.Namespace("a")
+	b, _ := p.Namespace("b")
+
+	if err := a.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if err := b.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), isenzo.NewQuery("2", "test")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	data := map[string]interface{}{"foo": "bar"}
+
+	results, err := p.MatchNamespaces(data, "a", "b")
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected %d namespace results; got %d", 2, len(results))
+	}
+
+	if res := results["a"]; len(res.Ids) != 1 || res.Ids[0] != "1" || res.QueriesRun != 1 {
+		t.Fatalf("expected namespace a results [1] with 1 query run; got %v with %d", res.Ids, res.QueriesRun)
+	}
+
+	if res := results["b"]; len(res.Ids) != 1 || res.QueriesRun != 1 {
+		t.Fatalf("expected namespace b results [1] with 1 query run; got %v with %d", res.Ids, res.QueriesRun)
+	}
+
+	res, err := p.Match(data)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if res.QueriesRun != 1 {
+		t.Fatalf("expected %d queries run; got %d", 1, res.QueriesRun)
+	}
+
+	if qrys := b.Queries(); len(qrys) != 2 {
+		t.Fatalf("expected %d queries; got %d", 2, len(qrys))
+	}
+}
+
+func TestPercolator_NamespaceLimit(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithNamespaceLimit(1))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	a, _ := p.Namespace("a")
+	if err := a.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := a.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:baz")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := a.Update([]isenzo.Query{isenzo.NewQuery("2", "foo:bar")}); err != isenzo.ErrorNamespaceLimit {
+		t.Fatalf("expected error namespace limit; got %v", err)
+	}
+
+	p.SetNamespaceLimit("a", 2)
+	if err := a.Update([]isenzo.Query{isenzo.NewQuery("2", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	stats := p.Namespaces()
+	if len(stats) != 1 || stats[0].Queries != 2 || stats[0].Limit != 2 {
+		t.Fatalf("expected namespace a with 2 queries and limit 2; got %+v", stats)
+	}
+}
+
+func TestPercolator_InvalidNamespace(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := p.Namespace("a/b"); err != isenzo.ErrorInvalidNamespace {
+		t.Fatalf("expected error invalid namespace; got %v", err)
+	}
+}
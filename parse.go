@@ -0,0 +1,213 @@
+package isenzo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bcampbell/qs"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+const nestedPrefix = "nested("
+
+// ErrorNestedPath is returned when a nested query uses a path the matcher
+// does not index as a nested array.
+var ErrorNestedPath = errors.New("nested query path is not a nested path of the matcher")
+
+// parseQuery parses a query string into a query.Query.
+//
+// On top of the query string syntax, nested(path, query) matches the query
+// against a single element of the nested array at path. Fields in the nested
+// query are relative to the path, e.g. nested(items, color:red AND size:L).
+func parseQuery(s string) (query.Query, error) {
+	nested := map[string]*matchers.NestedQuery{}
+
+	var buf bytes.Buffer
+	for {
+		i := indexNested(s)
+		if i < 0 {
+			buf.WriteString(s)
+			break
+		}
+		buf.WriteString(s[:i])
+
+		rest := s[i+len(nestedPrefix):]
+		end := indexClose(rest)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed nested query at %d", i)
+		}
+
+		nq, err := parseNested(rest[:end])
+		if err != nil {
+			return nil, err
+		}
+
+		placeholder := "__nested" + strconv.Itoa(len(nested)) + "__"
+		nested[placeholder] = nq
+		buf.WriteString(placeholder)
+
+		s = rest[end+1:]
+	}
+
+	q, err := qs.Parse(buf.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nested) == 0 {
+		return q, nil
+	}
+
+	return walkQuery(q, func(q query.Query) query.Query {
+		var term string
+		switch t := q.(type) {
+		case *query.MatchQuery:
+			term = t.Match
+		case *query.TermQuery:
+			term = t.Term
+		default:
+			return q
+		}
+
+		if nq, ok := nested[term]; ok {
+			return nq
+		}
+		return q
+	}), nil
+}
+
+// parseNested parses the "path, query" arguments of a nested query.
+func parseNested(args string) (*matchers.NestedQuery, error) {
+	i := strings.IndexByte(args, ',')
+	if i < 0 {
+		return nil, fmt.Errorf("nested query %q has no path", args)
+	}
+
+	path := strings.TrimSpace(args[:i])
+	if path == "" {
+		return nil, fmt.Errorf("nested query %q has no path", args)
+	}
+
+	q, err := parseQuery(strings.TrimSpace(args[i+1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	q = walkQuery(q, func(q query.Query) query.Query {
+		if fq, ok := q.(query.FieldableQuery); ok && fq.Field() != "" {
+			fq.SetField(path + "." + fq.Field())
+		}
+		return q
+	})
+
+	return matchers.NewNestedQuery(path, q), nil
+}
+
+// indexNested returns the index of the first nested query outside of quotes, or -1.
+func indexNested(s string) int {
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuote = !inQuote
+		default:
+			if inQuote || !strings.HasPrefix(s[i:], nestedPrefix) {
+				continue
+			}
+
+			if i == 0 || strings.IndexByte(" \t\n(+-", s[i-1]) >= 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// indexClose returns the index of the parenthesis closing an already opened
+// parenthesis, or -1.
+func indexClose(s string) int {
+	depth := 1
+	inQuote := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuote = !inQuote
+		case '(':
+			if !inQuote {
+				depth++
+			}
+		case ')':
+			if inQuote {
+				continue
+			}
+
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+// walkQuery walks the query tree depth first, replacing each query with the
+// result of fn.
+func walkQuery(q query.Query, fn func(query.Query) query.Query) query.Query {
+	switch t := q.(type) {
+	case *query.BooleanQuery:
+		if t.Must != nil {
+			t.Must = walkQuery(t.Must, fn)
+		}
+		if t.Should != nil {
+			t.Should = walkQuery(t.Should, fn)
+		}
+		if t.MustNot != nil {
+			t.MustNot = walkQuery(t.MustNot, fn)
+		}
+
+	case *query.ConjunctionQuery:
+		for i, c := range t.Conjuncts {
+			t.Conjuncts[i] = walkQuery(c, fn)
+		}
+
+	case *query.DisjunctionQuery:
+		for i, d := range t.Disjuncts {
+			t.Disjuncts[i] = walkQuery(d, fn)
+		}
+	}
+
+	return fn(q)
+}
+
+// checkNested returns ErrorNestedPath if a nested query of the query uses
+// a path that is not one of the nested paths.
+func checkNested(q query.Query, paths []string) error {
+	var err error
+	walkQuery(q, func(q query.Query) query.Query {
+		nq, ok := q.(*matchers.NestedQuery)
+		if !ok || err != nil {
+			return q
+		}
+
+		err = ErrorNestedPath
+		for _, path := range paths {
+			if nq.Path == path {
+				err = checkNested(nq.Query, paths)
+				break
+			}
+		}
+		return q
+	})
+
+	return err
+}
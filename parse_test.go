@@ -0,0 +1,155 @@
+package isenzo_test
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+func TestPercolator_MatchNested(t *testing.T) {
+	p, err := isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(matchers.NewIndexMatcherFactory(bleve.NewIndexMapping(), matchers.WithNestedPaths("items"))),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("flat", "+items.color:red +items.size:L"),
+		isenzo.NewQuery("nested", "+status:open +nested(items, +color:red +size:L)"),
+		isenzo.NewQuery("not-nested", "-nested(items, +color:red +size:L)"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	tests := []struct {
+		doc  map[string]interface{}
+		want []string
+	}{
+		{
+			doc: map[string]interface{}{
+				"status": "open",
+				"items": []interface{}{
+					map[string]interface{}{"color": "red", "size": "M"},
+					map[string]interface{}{"color": "blue", "size": "L"},
+				},
+			},
+			want: []string{"flat", "not-nested"},
+		},
+		{
+			doc: map[string]interface{}{
+				"status": "open",
+				"items": []interface{}{
+					map[string]interface{}{"color": "blue", "size": "M"},
+					map[string]interface{}{"color": "red", "size": "L"},
+				},
+			},
+			want: []string{"flat", "nested"},
+		},
+	}
+
+	for i, tt := range tests {
+		results, err := p.Match(tt.doc)
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if len(results.Errs) != 0 {
+			t.Fatalf("expected no errors; got %v", results.Errs)
+		}
+
+		got := map[string]bool{}
+		for _, id := range results.Ids {
+			got[id] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%d: expected results %v; got %v", i, tt.want, results.Ids)
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Fatalf("%d: expected results %v; got %v", i, tt.want, results.Ids)
+			}
+		}
+	}
+}
+
+func TestPercolator_UpdateWithInvalidNested(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	qrys := []string{
+		"nested(items, color:red",
+		"nested(color:red)",
+		"nested(, color:red)",
+	}
+	for _, qry := range qrys {
+		if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", qry)}); err == nil {
+			t.Fatalf("expected errors for %q; got none", qry)
+		}
+	}
+}
+
+func TestPercolator_UpdateWithUnconfiguredNested(t *testing.T) {
+	tests := []struct {
+		factory matchers.Factory
+		qry     string
+	}{
+		{
+			factory: matchers.NewIndexMatcherFactory(bleve.NewIndexMapping()),
+			qry:     "nested(items, color:red)",
+		},
+		{
+			factory: matchers.NewIndexMatcherFactory(bleve.NewIndexMapping(), matchers.WithNestedPaths("items")),
+			qry:     "+status:open -nested(lines, color:red)",
+		},
+	}
+
+	for _, tt := range tests {
+		p, err := isenzo.NewPercolator(isenzo.WithMatcherFactory(tt.factory))
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", tt.qry)}); err != isenzo.ErrorNestedPath {
+			t.Fatalf("expected ErrorNestedPath for %q; got %v", tt.qry, err)
+		}
+	}
+}
+
+func TestPercolator_PresearchNested(t *testing.T) {
+	p, err := isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(matchers.NewIndexMatcherFactory(bleve.NewIndexMapping(), matchers.WithNestedPaths("items"))),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("red", "nested(items, +color:red +size:L)"),
+		isenzo.NewQuery("green", "nested(items, +color:green +size:L)"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	results, err := p.Match(map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"color": "red", "size": "L"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if len(results.Ids) != 1 || results.Ids[0] != "red" {
+		t.Fatalf("expected results [red]; got %v", results.Ids)
+	}
+	if results.QueriesRun != 1 {
+		t.Fatalf("expected 1 query run; got %d", results.QueriesRun)
+	}
+}
@@ -1,14 +1,23 @@
 package isenzo
 
 import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/bcampbell/qs"
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/collector"
 	"github.com/blevesearch/bleve/search/query"
 	"github.com/nrwiersma/isenzo/matchers"
 	"github.com/nrwiersma/isenzo/presearchers"
+	"github.com/rcrowley/go-metrics"
 )
 
 type optionsFunc func(*Percolator)
@@ -21,20 +30,255 @@ func WithMatcherFactory(matcher matchers.Factory) optionsFunc {
 }
 
 // WithPresearcher sets the presearcher on the Percolator.
+//
+// A nil presearcher disables presearching, running every query against each document.
 func WithPresearcher(presearcher presearchers.Presearcher) optionsFunc {
 	return func(p *Percolator) {
 		p.presearcher = presearcher
 	}
 }
 
+// WithMetrics sets the metrics registry on the Percolator.
+//
+// Matcher factories implementing matchers.Instrumenter report to the same registry.
+func WithMetrics(r metrics.Registry) optionsFunc {
+	return func(p *Percolator) {
+		p.registry = r
+	}
+}
+
+// WithSlowQueryLog sets the slow query threshold on the Percolator.
+//
+// Query evaluations taking at least the threshold are recorded, and logged
+// to the logger if it is not nil.
+func WithSlowQueryLog(threshold time.Duration, logger *log.Logger) optionsFunc {
+	return func(p *Percolator) {
+		p.slowThreshold = threshold
+		p.slowLogger = logger
+	}
+}
+
+// WithQuarantine sets the quarantine policy on the Percolator.
+//
+// A query that fails or exceeds its time budget the given number of times
+// within the window is disabled until it is reinstated or updated. The
+// handler, if not nil, is called asynchronously for every quarantine event,
+// in the order the events occurred.
+func WithQuarantine(failures int, window time.Duration, handler func(QuarantineEvent)) optionsFunc {
+	return func(p *Percolator) {
+		p.quarantineFailures = failures
+		p.quarantineWindow = window
+		p.quarantineHandler = handler
+	}
+}
+
+// WithClock sets the function returning the current time on the Percolator.
+func WithClock(now func() time.Time) optionsFunc {
+	return func(p *Percolator) {
+		p.now = now
+	}
+}
+
+// WithNamespaceLimit sets the default maximum number of queries per namespace on the Percolator.
+//
+// A limit of 0 means namespaces are unlimited.
+func WithNamespaceLimit(max int) optionsFunc {
+	return func(p *Percolator) {
+		p.namespaceLimit = max
+	}
+}
+
+// WithChangeLog sets the number of query changes kept for watchers on the Percolator.
+func WithChangeLog(size int) optionsFunc {
+	return func(p *Percolator) {
+		p.changeLogSize = size
+	}
+}
+
+// WithHistory sets the number of versions kept per query on the Percolator.
+func WithHistory(size int) optionsFunc {
+	return func(p *Percolator) {
+		p.historySize = size
+	}
+}
+
+// WithDeletedHistory sets the number of deleted queries whose history is
+// kept on the Percolator. The histories of older deleted queries are dropped.
+func WithDeletedHistory(size int) optionsFunc {
+	return func(p *Percolator) {
+		p.deletedSize = size
+	}
+}
+
+// WithShadowReport sets the number of disagreements sampled per shadow query,
+// and the interval and number of buckets the shadow counts are kept for.
+func WithShadowReport(samples int, interval time.Duration, buckets int) optionsFunc {
+	return func(p *Percolator) {
+		p.shadowSamples = samples
+		p.shadowInterval = interval
+		p.shadowBuckets = buckets
+	}
+}
+
+// WithBacktest sets the size and age of the corpus of matched documents
+// kept for backtesting on the Percolator.
+//
+// A size of 0 disables backtesting, and a window of 0 keeps documents
+// regardless of their age. The fields of a matched *document.Document are
+// recorded as is, so must not be modified after the match.
+func WithBacktest(size int, window time.Duration) optionsFunc {
+	return func(p *Percolator) {
+		p.backtestSize = size
+		p.backtestWindow = window
+	}
+}
+
+// WithRetroactive sets the recent document buffer on the Percolator, bounded
+// by count and age, enabling retroactive matching.
+//
+// Queries added or changed by an update are matched against the buffered
+// documents in the background, and the results are passed to the handler
+// with Retroactive set. Updates are matched in order by a single worker, and
+// are dropped when it falls behind. A size of 0 disables retroactive
+// matching, and a window of 0 keeps documents regardless of their age.
+//
+// Matched documents are held by the buffer, so must not be modified after
+// the match.
+func WithRetroactive(size int, window time.Duration, handler func(*Results)) optionsFunc {
+	return func(p *Percolator) {
+		p.recent = &recentDocs{size: size, window: window}
+		p.retroactiveHandler = handler
+	}
+}
+
+// WithJanitor sets the interval at which expired queries are removed from the Percolator.
+func WithJanitor(interval time.Duration) optionsFunc {
+	return func(p *Percolator) {
+		p.janitorInterval = interval
+	}
+}
+
+type cachedQuery struct {
+	namespace string
+	query     Query
+	qry       query.Query
+	schedule  *schedule
+	presearch *document.Document
+	stats     *queryStats
+	breaker   *breaker
+}
+
+// newCachedQuery parses and validates a query in a namespace.
+func (p *Percolator) newCachedQuery(ns string, qry Query) (*cachedQuery, error) {
+	q, err := parseQuery(qry.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, ok := p.matcher.(matchers.Nester); ok {
+		if err := checkNested(q, n.NestedPaths()); err != nil {
+			return nil, err
+		}
+	}
+
+	if !qry.ActiveFrom.IsZero() && !qry.ExpiresAt.IsZero() && !qry.ExpiresAt.After(qry.ActiveFrom) {
+		return nil, fmt.Errorf("query %s expires before it is active", qry.Id)
+	}
+
+	sch, err := parseSchedule(qry.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachedQuery{
+		namespace: ns,
+		query:     qry,
+		qry:       q,
+		schedule:  sch,
+		stats:     newQueryStats(p.now()),
+		breaker:   &breaker{},
+	}, nil
+}
+
+// active determines if the query should be evaluated at the given time.
+func (c *cachedQuery) active(now time.Time) bool {
+	if !c.query.ActiveFrom.IsZero() && now.Before(c.query.ActiveFrom) {
+		return false
+	}
+
+	if c.expired(now) {
+		return false
+	}
+
+	if c.schedule != nil && !c.schedule.active(now) {
+		return false
+	}
+
+	return !c.breaker.isQuarantined()
+}
+
+// expired determines if the query has expired at the given time.
+func (c *cachedQuery) expired(now time.Time) bool {
+	return !c.query.ExpiresAt.IsZero() && !now.Before(c.query.ExpiresAt)
+}
+
 // Percolator represents a percolator instance.
 type Percolator struct {
-	cache     map[string]query.Query
-	cacheLock sync.RWMutex
+	cache      map[string]*cachedQuery
+	namespaces map[string]*namespace
+	cacheLock  sync.RWMutex
 
 	queryIndex  *presearchers.Index
 	presearcher presearchers.Presearcher
 	matcher     matchers.Factory
+
+	registry metrics.Registry
+	metrics  *percolatorMetrics
+
+	slowThreshold time.Duration
+	slowLogger    *log.Logger
+	slow          []SlowQuery
+	slowLock      sync.Mutex
+
+	quarantineFailures int
+	quarantineWindow   time.Duration
+	quarantineHandler  func(QuarantineEvent)
+	quarantineEvents   []QuarantineEvent
+	quarantineLock     sync.Mutex
+	quarantineSignal   chan struct{}
+
+	namespaceLimit int
+
+	changes       *changeLog
+	changeLogSize int
+
+	history        map[string][]QueryVersion
+	historySize    int
+	deleted        []string
+	deletedSize    int
+	historyDropped uint64
+
+	shadows        map[string]*shadowQuery
+	shadowSeq      uint64
+	shadowSamples  int
+	shadowInterval time.Duration
+	shadowBuckets  int
+
+	recent             *recentDocs
+	retroactiveHandler func(*Results)
+
+	corpus         *corpus
+	backtestSize   int
+	backtestWindow time.Duration
+
+	now             func() time.Time
+	janitorInterval time.Duration
+	done            chan struct{}
+
+	state      int
+	stateLock  sync.RWMutex
+	inflight   sync.WaitGroup
+	background sync.WaitGroup
 }
 
 // NewPercolator creates a new Percolator.
@@ -45,20 +289,86 @@ func NewPercolator(opts ...optionsFunc) (*Percolator, error) {
 	}
 
 	p := &Percolator{
-		cache:      map[string]query.Query{},
-		queryIndex: queryIndex,
+		cache:         map[string]*cachedQuery{},
+		namespaces:    map[string]*namespace{},
+		queryIndex:    queryIndex,
+		presearcher:   &presearchers.TermPresearcher{},
+		now:           time.Now,
+		done:          make(chan struct{}),
+		changeLogSize: defaultChangeLogSize,
+		history:       map[string][]QueryVersion{},
+		historySize:   defaultHistorySize,
+		deletedSize:   defaultDeletedHistorySize,
+
+		shadows:        map[string]*shadowQuery{},
+		shadowSamples:  defaultShadowSamples,
+		shadowInterval: defaultShadowInterval,
+		shadowBuckets:  defaultShadowBuckets,
 	}
 
 	for _, o := range opts {
 		o(p)
 	}
 
+	p.changes = newChangeLog(p.changeLogSize)
+
+	if p.retroactiveHandler == nil || p.recent.size <= 0 {
+		p.recent = nil
+	}
+
 	if p.matcher == nil {
 		p.matcher = matchers.NewIndexMatcherFactory(bleve.NewIndexMapping())
 	}
 
-	if p.presearcher == nil {
-		p.presearcher = &presearchers.TermPresearcher{}
+	if t, ok := p.presearcher.(*presearchers.TermPresearcher); ok && t.Mapping == nil {
+		// Documents and queries are analyzed as the matcher analyzes them.
+		if mapper, ok := p.matcher.(matchers.Mapper); ok && mapper.Mapping() != nil {
+			p.presearcher = &presearchers.TermPresearcher{Mapping: mapper.Mapping()}
+		}
+	}
+
+	if o, ok := p.matcher.(matchers.Observable); ok {
+		if err := o.Observe(p.observe); err != nil {
+			return nil, err
+		}
+	}
+
+	p.metrics = newPercolatorMetrics(p.registry)
+	if i, ok := p.matcher.(matchers.Instrumenter); ok && p.registry != nil {
+		i.Instrument(p.registry)
+	}
+
+	if p.backtestSize > 0 {
+		var m mapping.IndexMapping = bleve.NewIndexMapping()
+		if mapper, ok := p.matcher.(matchers.Mapper); ok && mapper.Mapping() != nil {
+			m = mapper.Mapping()
+		}
+
+		if p.corpus, err = newCorpus(m, p.backtestSize, p.backtestWindow); err != nil {
+			return nil, err
+		}
+
+		p.background.Add(1)
+		go p.recordCorpus()
+	}
+
+	if p.recent != nil {
+		p.recent.queue = make(chan retroactTask, retroactQueueSize)
+
+		p.background.Add(1)
+		go p.matchRetroactive()
+	}
+
+	if p.quarantineHandler != nil {
+		p.quarantineSignal = make(chan struct{}, 1)
+
+		p.background.Add(1)
+		go p.deliverQuarantine()
+	}
+
+	if p.janitorInterval > 0 {
+		p.background.Add(1)
+		go p.janitor()
 	}
 
 	return p, nil
@@ -66,45 +376,413 @@ func NewPercolator(opts ...optionsFunc) (*Percolator, error) {
 
 // Update sets the queries on the Percolator.
 func (p *Percolator) Update(qrys []Query) error {
+	return p.update(DefaultNamespace, qrys)
+}
+
+// update sets the queries in a namespace.
+func (p *Percolator) update(ns string, qrys []Query) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
 	p.cacheLock.Lock()
 	defer p.cacheLock.Unlock()
 
-	for _, qry := range qrys {
-		q, err := qs.Parse(qry.Query)
+	return p.updateLocked(ns, qrys)
+}
+
+// updateLocked sets the queries in a namespace. The cache lock must be held
+// for writing.
+func (p *Percolator) updateLocked(ns string, qrys []Query) error {
+	if err := p.checkLimit(ns, qrys); err != nil {
+		return err
+	}
+
+	// Parse the whole batch first, so an invalid query leaves the
+	// Percolator unchanged.
+	cached := make([]*cachedQuery, len(qrys))
+	for i, qry := range qrys {
+		c, err := p.newCachedQuery(ns, qry)
 		if err != nil {
 			return err
 		}
+		cached[i] = c
+	}
+
+	changed := map[string]*cachedQuery{}
+	for i, qry := range qrys {
+		c := cached[i]
+		k := key(ns, qry.Id)
+		if p.presearcher != nil {
+			c.presearch = p.presearcher.IndexQuery(k, c.qry)
+			if err := indexPresearch(p.queryIndex, ns, c.presearch); err != nil {
+				return err
+			}
+		}
+
+		old, ok := p.cache[k]
+		if ok && old.query.Query == qry.Query {
+			c.stats = old.stats
+		} else {
+			changed[k] = c
+		}
+		p.cache[k] = c
 
-		p.cache[qry.Id] = q
+		// An update to an identical query is not a change.
+		switch {
+		case !ok:
+			p.namespace(ns).queries++
+			p.recordChange(ChangeAdded, ns, qry)
+		case !reflect.DeepEqual(old.query, qry):
+			p.recordChange(ChangeUpdated, ns, qry)
+		}
 	}
 
+	p.retroact(ns, changed)
+
 	return nil
 }
 
+// Delete removes the queries with the given ids from the Percolator.
+func (p *Percolator) Delete(ids []string) error {
+	return p.deleteIds(DefaultNamespace, ids)
+}
+
+// deleteIds removes the queries with the given ids from a namespace.
+func (p *Percolator) deleteIds(ns string, ids []string) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	for _, id := range ids {
+		if err := p.delete(key(ns, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// delete removes a query from the cache and query index. The cache lock must be held.
+func (p *Percolator) delete(k string) error {
+	c, ok := p.cache[k]
+	if !ok {
+		return nil
+	}
+
+	if p.presearcher != nil {
+		if err := p.queryIndex.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	delete(p.cache, k)
+	p.namespace(c.namespace).queries--
+	p.recordChange(ChangeDeleted, c.namespace, c.query)
+	p.forget(k)
+
+	return nil
+}
+
+// forget discards the state the matcher keeps for the matcher keys.
+func (p *Percolator) forget(keys ...string) {
+	if f, ok := p.matcher.(matchers.Forgetter); ok && len(keys) > 0 {
+		f.Forget(keys...)
+	}
+}
+
+// Query returns the query with the given id.
+func (p *Percolator) Query(id string) (Query, bool) {
+	return p.query(DefaultNamespace, id)
+}
+
+// query returns the query with the given id in a namespace.
+func (p *Percolator) query(ns, id string) (Query, bool) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	c, ok := p.cache[key(ns, id)]
+	if !ok {
+		return Query{}, false
+	}
+
+	return c.query, true
+}
+
+// Queries returns all queries on the Percolator, ordered by id.
+func (p *Percolator) Queries() []Query {
+	return p.queries(DefaultNamespace)
+}
+
+// queries returns all queries in a namespace, ordered by id.
+func (p *Percolator) queries(ns string) []Query {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	qrys := make([]Query, 0, len(p.cache))
+	for _, c := range p.cache {
+		if c.namespace != ns {
+			continue
+		}
+
+		qrys = append(qrys, c.query)
+	}
+
+	sort.Slice(qrys, func(i, j int) bool {
+		return qrys[i].Id < qrys[j].Id
+	})
+
+	return qrys
+}
+
 // Matches matches a document and applies the changes on the first matching Query.
-func (p *Percolator) Match(doc map[string]interface{}) (*Results, error) {
+//
+// The document can be a map, a struct (honoring json tags), raw JSON as a
+// json.RawMessage or []byte, or a pre-built *document.Document.
+func (p *Percolator) Match(doc interface{}) (*Results, error) {
+	res, err := p.match(doc, []string{DefaultNamespace})
+	if err != nil {
+		return nil, err
+	}
+
+	return res[DefaultNamespace], nil
+}
+
+// MatchNamespaces matches a document against the queries of the given
+// namespaces, returning the results per namespace.
+func (p *Percolator) MatchNamespaces(doc interface{}, namespaces ...string) (map[string]*Results, error) {
+	return p.match(doc, namespaces)
+}
+
+// match matches a document against the queries of the given namespaces.
+func (p *Percolator) match(doc interface{}, namespaces []string) (map[string]*Results, error) {
+	if err := p.begin(); err != nil {
+		return nil, err
+	}
+	defer p.end()
+
 	startMatch := time.Now()
 
-	//TODO: Pre-search queries
+	start := time.Now()
+	doc, err := decodeDocument(doc)
+	if err != nil {
+		p.metrics.mapping.UpdateSince(start)
+		p.metrics.mappingErrors.Inc(1)
+		return nil, err
+	}
 
-	m, err := p.matcher.New(doc)
+	mapped, err := p.matcher.Map(doc)
+	p.metrics.mapping.UpdateSince(start)
 	if err != nil {
+		p.metrics.mappingErrors.Inc(1)
+		return nil, err
+	}
+
+	start = time.Now()
+	m, err := p.matcher.New(mapped)
+	p.metrics.build.UpdateSince(start)
+	if err != nil {
+		p.metrics.buildErrors.Inc(1)
 		return nil, err
 	}
 
-	// Run queries
 	p.cacheLock.RLock()
 	defer p.cacheLock.RUnlock()
 
-	for id, qry := range p.cache {
-		m.Match(id, qry)
+	results := make(map[string]*Results, len(namespaces))
+	for _, ns := range namespaces {
+		results[ns] = &Results{Ids: []string{}, Errs: []error{}, Namespace: ns}
 	}
 
-	ids, errs := m.Finish()
-	return &Results{
-		Ids:        ids,
-		Errs:       errs,
-		Took:       time.Since(startMatch),
-		QueriesRun: -1,
-	}, nil
+	start = time.Now()
+	keys, err := p.presearch(doc, namespaces)
+	p.metrics.presearch.UpdateSince(start)
+	if err != nil {
+		p.metrics.presearchErrors.Inc(1)
+		m.Finish()
+		return nil, err
+	}
+
+	// Run queries
+	start = time.Now()
+	now := p.now()
+	run := 0
+	for _, k := range keys {
+		c, ok := p.cache[k]
+		if !ok || !c.active(now) {
+			continue
+		}
+
+		res, ok := results[c.namespace]
+		if !ok {
+			continue
+		}
+
+		run++
+		res.QueriesRun++
+		atomic.AddInt64(&c.stats.candidates, 1)
+		m.Match(k, c.qry)
+	}
+
+	// Run shadow queries alongside the live queries. A shadow query is not
+	// compared when its live query is not evaluated, or fails.
+	var shadows []*shadowQuery
+	var shadowUncompared []bool
+	var shadowIndex, liveIndex map[string]int
+	if len(p.shadows) > 0 {
+		shadowIndex = map[string]int{}
+		liveIndex = map[string]int{}
+		for k, s := range p.shadows {
+			if _, ok := results[s.c.namespace]; !ok || !s.c.active(now) {
+				continue
+			}
+
+			m.Match(s.key, s.c.qry)
+			shadowIndex[s.key] = len(shadows)
+			liveIndex[k] = len(shadows)
+			c, ok := p.cache[k]
+			shadowUncompared = append(shadowUncompared, ok && !c.active(now))
+			shadows = append(shadows, s)
+		}
+	}
+
+	matched, errs := m.Finish()
+	p.metrics.execute.UpdateSince(start)
+	p.metrics.executeErrors.Inc(int64(len(errs)))
+
+	p.metrics.queries.Update(int64(len(p.cache)))
+	p.metrics.candidates.Update(int64(run))
+
+	var shadowMatched, shadowFailed []bool
+	if len(shadows) > 0 {
+		shadowMatched = make([]bool, len(shadows))
+		shadowFailed = make([]bool, len(shadows))
+	}
+	for _, k := range matched {
+		if i, ok := shadowIndex[k]; ok {
+			shadowMatched[i] = true
+			continue
+		}
+
+		ns, id := splitKey(k)
+		if res, ok := results[ns]; ok {
+			res.Ids = append(res.Ids, id)
+		}
+	}
+
+	for _, err := range errs {
+		qerr, ok := err.(*matchers.QueryError)
+		if !ok {
+			for _, res := range results {
+				res.Errs = append(res.Errs, err)
+			}
+			continue
+		}
+
+		if i, ok := shadowIndex[qerr.Id]; ok {
+			shadowFailed[i] = true
+			continue
+		}
+		if i, ok := liveIndex[qerr.Id]; ok {
+			shadowUncompared[i] = true
+		}
+
+		ns, id := splitKey(qerr.Id)
+		if res, ok := results[ns]; ok {
+			res.Errs = append(res.Errs, &matchers.QueryError{Id: id, Err: qerr.Err})
+		}
+	}
+
+	if len(shadows) > 0 {
+		live := make(map[string]bool)
+		for ns, res := range results {
+			for _, id := range res.Ids {
+				live[key(ns, id)] = true
+			}
+		}
+
+		for i, s := range shadows {
+			k := key(s.c.namespace, s.c.query.Id)
+			s.observe(p, now, doc, mapped, live[k], shadowUncompared[i], shadowMatched[i], shadowFailed[i])
+		}
+	}
+
+	if p.recent != nil {
+		p.recent.add(doc, namespaces, now)
+	}
+
+	if p.corpus != nil {
+		p.corpus.record(mapped, namespaces, now)
+	}
+
+	took := time.Since(startMatch)
+	p.metrics.latency.Update(took)
+
+	for ns, res := range results {
+		res.Took = took
+
+		if state, ok := p.namespaces[ns]; ok {
+			atomic.AddInt64(&state.documents, 1)
+			atomic.AddInt64(&state.matches, int64(len(res.Ids)))
+		}
+	}
+
+	return results, nil
+}
+
+// indexPresearch indexes the presearch document of a query in a namespace.
+func indexPresearch(i *presearchers.Index, ns string, doc *document.Document) error {
+	doc.AddField(newNamespaceField(ns))
+	return i.Index(doc)
+}
+
+// presearch returns the keys of the queries in the namespaces that could match the document.
+func (p *Percolator) presearch(doc interface{}, namespaces []string) ([]string, error) {
+	if len(namespaces) == 0 {
+		return []string{}, nil
+	}
+
+	if p.presearcher == nil {
+		keys := make([]string, 0, len(p.cache))
+		for k, c := range p.cache {
+			if c.breaker.isQuarantined() {
+				continue
+			}
+			keys = append(keys, k)
+		}
+
+		return keys, nil
+	}
+
+	count, err := p.queryIndex.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return []string{}, nil
+	}
+
+	q := query.NewConjunctionQuery([]query.Query{
+		p.presearcher.BuildQuery(doc),
+		newNamespaceQuery(namespaces),
+	})
+
+	col := collector.NewTopNCollector(int(count), 0, search.SortOrder{&search.SortDocID{}})
+	if _, err := p.queryIndex.Search(q, col); err != nil {
+		return nil, err
+	}
+
+	hits := col.Results()
+	keys := make([]string, len(hits))
+	for i, hit := range hits {
+		keys[i] = hit.ID
+	}
+
+	return keys, nil
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
 	"github.com/nrwiersma/isenzo"
 	"github.com/nrwiersma/isenzo/matchers"
+	"github.com/rcrowley/go-metrics"
 )
 
 func TestPercolator_Match(t *testing.T) {
@@ -55,6 +56,122 @@ func TestPercolator_UpdateWithErrors(t *testing.T) {
 	}
 }
 
+func TestPercolator_UpdateWithErrorsIsAtomic(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+		isenzo.NewQuery("2", "+-"),
+	})
+	if err == nil {
+		t.Fatal("expected errors; got none")
+	}
+
+	if qrys := p.Queries(); len(qrys) != 0 {
+		t.Fatalf("expected no queries; got %v", qrys)
+	}
+}
+
+func TestPercolator_Delete(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+		isenzo.NewQuery("2", "bar"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Delete([]string{"2"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if qrys := p.Queries(); len(qrys) != 1 || qrys[0].Id != "1" {
+		t.Fatalf("expected queries [1]; got %v", qrys)
+	}
+
+	results, err := p.Match(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if len(results.Ids) != 1 {
+		t.Fatalf("expected %d results; got %v", 1, len(results.Ids))
+	}
+}
+
+func TestPercolator_MatchWithoutPresearcher(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithPresearcher(nil))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+		isenzo.NewQuery("2", "test"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	results, err := p.Match(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if results.QueriesRun != 2 {
+		t.Fatalf("expected %d queries run; got %v", 2, results.QueriesRun)
+	}
+
+	if len(results.Ids) != 1 {
+		t.Fatalf("expected %d results; got %v", 1, len(results.Ids))
+	}
+}
+
+func TestPercolator_MatchWithMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	p, err := isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(matchers.NewParallelMatcherFactory(matchers.NewIndexMatcherFactory(bleve.NewIndexMapping()), 2)),
+		isenzo.WithMetrics(r),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+		isenzo.NewQuery("2", "test"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if c := metrics.GetOrRegisterTimer("match.latency", r).Count(); c != 1 {
+		t.Fatalf("expected %d match latency; got %d", 1, c)
+	}
+
+	if v := metrics.GetOrRegisterGauge("match.queries", r).Value(); v != 2 {
+		t.Fatalf("expected %d queries; got %d", 2, v)
+	}
+
+	for _, name := range []string{"matcher.index.pool.misses", "matcher.parallel.queue"} {
+		if r.Get(name) == nil {
+			t.Fatalf("expected metric %s; got none", name)
+		}
+	}
+}
+
 func BenchmarkPercolator_1Rules(b *testing.B) {
 	b.ReportAllocs()
 
@@ -2,20 +2,234 @@ package presearchers
 
 import (
 	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/mapping"
 	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/matchers"
 )
 
+// AnyField is the field of queries that are candidates for every document,
+// as no terms could be extracted from them.
+const AnyField = "_any"
+
+var (
+	anyTerm = []byte("any")
+
+	defaultMapping = mapping.NewIndexMapping()
+)
+
+// TermPresearcher selects the queries sharing at least one term with a document.
+//
+// Terms are extracted from term, match and phrase queries, combined by
+// boolean, conjunction, disjunction and nested queries. Queries without
+// extractable terms are run against every document.
 type TermPresearcher struct {
+	// Mapping is the index mapping documents and queries are analyzed with.
+	// It must match the mapping of the matcher. The Percolator sets it to the
+	// mapping of its matcher, otherwise it defaults to bleve's default index
+	// mapping.
+	Mapping mapping.IndexMapping
 }
 
 // BuildQuery builds a query.Query from a document.
-func (p *TermPresearcher) BuildQuery(doc map[string]interface{}) query.Query {
-	return query.NewMatchAllQuery()
+func (p *TermPresearcher) BuildQuery(doc interface{}) query.Query {
+	m := p.mapping()
+
+	var d *document.Document
+	switch v := doc.(type) {
+	case *document.Document:
+		d = v
+	case *matchers.NestedDocument:
+		d = v.Doc
+	default:
+		d = document.NewDocument(matchers.DocumentID)
+		if err := m.MapDocument(d, doc); err != nil {
+			return query.NewMatchAllQuery()
+		}
+	}
+
+	any := query.NewTermQuery(string(anyTerm))
+	any.SetField(AnyField)
+	qrys := []query.Query{any}
+
+	seen := map[string]bool{}
+	add := func(field, term string) {
+		k := field + "\x00" + term
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+
+		q := query.NewTermQuery(term)
+		q.SetField(field)
+		qrys = append(qrys, q)
+	}
+
+	for _, f := range d.Fields {
+		if _, ok := f.(*document.TextField); !ok {
+			continue
+		}
+
+		_, freqs := f.Analyze()
+		for term := range freqs {
+			add(f.Name(), term)
+			add(m.DefaultSearchField(), term)
+		}
+	}
+
+	return query.NewDisjunctionQuery(qrys)
 }
 
 // IndexQuery creates a document.Document from a query.Query.
-func (p *TermPresearcher) IndexQuery(id string, query query.Query) *document.Document {
+func (p *TermPresearcher) IndexQuery(id string, q query.Query) *document.Document {
 	doc := document.NewDocument(id)
 
+	terms, ok := p.extract(q)
+	if !ok {
+		doc.AddField(document.NewTextFieldWithIndexingOptions(AnyField, nil, anyTerm, document.IndexField))
+		return doc
+	}
+
+	for field, ts := range terms {
+		for _, t := range ts {
+			doc.AddField(document.NewTextFieldWithIndexingOptions(field, nil, []byte(t), document.IndexField))
+		}
+	}
+
 	return doc
 }
+
+// extract returns the terms by field, one of which a document must contain
+// to match the query, or false if no such terms could be extracted.
+func (p *TermPresearcher) extract(q query.Query) (map[string][]string, bool) {
+	switch t := q.(type) {
+	case *query.TermQuery:
+		return map[string][]string{p.field(t.FieldVal): {t.Term}}, true
+
+	case *query.MatchQuery:
+		if t.Fuzziness > 0 || t.Prefix > 0 {
+			return nil, false
+		}
+		return p.analyze(t.FieldVal, t.Analyzer, t.Match)
+
+	case *query.MatchPhraseQuery:
+		return p.analyze(t.FieldVal, t.Analyzer, t.MatchPhrase)
+
+	case *query.ConjunctionQuery:
+		// Any conjunct must match, so the one with the fewest terms is used.
+		var best map[string][]string
+		for _, c := range t.Conjuncts {
+			terms, ok := p.extract(c)
+			if ok && (best == nil || count(terms) < count(best)) {
+				best = terms
+			}
+		}
+		return best, best != nil
+
+	case *query.DisjunctionQuery:
+		if len(t.Disjuncts) == 0 {
+			return nil, false
+		}
+
+		terms := map[string][]string{}
+		for _, d := range t.Disjuncts {
+			dt, ok := p.extract(d)
+			if !ok {
+				return nil, false
+			}
+
+			for field, ts := range dt {
+				terms[field] = append(terms[field], ts...)
+			}
+		}
+		return terms, true
+
+	case *query.BooleanQuery:
+		// Without must clauses, a document must match one of the should clauses.
+		if !empty(t.Must) {
+			if terms, ok := p.extract(t.Must); ok {
+				return terms, true
+			}
+		}
+
+		if should, ok := t.Should.(*query.DisjunctionQuery); ok && !empty(should) && (empty(t.Must) || should.Min > 0) {
+			return p.extract(should)
+		}
+		return nil, false
+
+	case *matchers.NestedQuery:
+		// The document holds the terms of all its nested elements, so a
+		// matching element implies a matching document.
+		return p.extract(t.Query)
+	}
+
+	return nil, false
+}
+
+// analyze returns the terms of the text analyzed as a match query on the field.
+func (p *TermPresearcher) analyze(field, analyzer, text string) (map[string][]string, bool) {
+	m := p.mapping()
+
+	field = p.field(field)
+	if analyzer == "" {
+		analyzer = m.AnalyzerNameForPath(field)
+	}
+
+	a := m.AnalyzerNamed(analyzer)
+	if a == nil {
+		return nil, false
+	}
+
+	tokens := a.Analyze([]byte(text))
+	if len(tokens) == 0 {
+		return nil, false
+	}
+
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		terms[i] = string(token.Term)
+	}
+
+	return map[string][]string{field: terms}, true
+}
+
+// field returns the field searched by a query on the given field.
+func (p *TermPresearcher) field(field string) string {
+	if field == "" {
+		return p.mapping().DefaultSearchField()
+	}
+
+	return field
+}
+
+// mapping returns the index mapping of the presearcher.
+func (p *TermPresearcher) mapping() mapping.IndexMapping {
+	if p.Mapping == nil {
+		return defaultMapping
+	}
+
+	return p.Mapping
+}
+
+// empty determines if a boolean clause matches nothing by itself.
+func empty(q query.Query) bool {
+	switch t := q.(type) {
+	case nil:
+		return true
+	case *query.ConjunctionQuery:
+		return len(t.Conjuncts) == 0
+	case *query.DisjunctionQuery:
+		return len(t.Disjuncts) == 0
+	}
+
+	return false
+}
+
+// count returns the number of terms.
+func count(terms map[string][]string) int {
+	n := 0
+	for _, ts := range terms {
+		n += len(ts)
+	}
+
+	return n
+}
@@ -0,0 +1,93 @@
+package presearchers_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/collector"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo/matchers"
+	"github.com/nrwiersma/isenzo/presearchers"
+)
+
+func TestTermPresearcher(t *testing.T) {
+	p := &presearchers.TermPresearcher{}
+
+	color := bleve.NewMatchQuery("red")
+	color.SetField("items.color")
+	size := bleve.NewMatchQuery("L")
+	size.SetField("items.size")
+	status := bleve.NewTermQuery("open")
+	status.SetField("status")
+	wildcard := bleve.NewWildcardQuery("gr*")
+	wildcard.SetField("status")
+
+	qrys := map[string]query.Query{
+		"term":        status,
+		"match":       bleve.NewMatchQuery("hello world"),
+		"conjunction": bleve.NewConjunctionQuery(status, color),
+		"disjunction": bleve.NewDisjunctionQuery(size, color),
+		"nested":      matchers.NewNestedQuery("items", bleve.NewConjunctionQuery(color, size)),
+		"wildcard":    wildcard,
+		"mixed":       bleve.NewDisjunctionQuery(status, wildcard),
+	}
+
+	tests := []struct {
+		doc  map[string]interface{}
+		want []string
+	}{
+		{
+			doc:  map[string]interface{}{"status": "open"},
+			want: []string{"conjunction", "mixed", "term", "wildcard"},
+		},
+		{
+			doc:  map[string]interface{}{"body": "Hello"},
+			want: []string{"match", "mixed", "wildcard"},
+		},
+		{
+			doc: map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"color": "red", "size": "M"},
+				},
+			},
+			want: []string{"disjunction", "mixed", "nested", "wildcard"},
+		},
+	}
+
+	index, err := presearchers.NewIndex(mapping.NewIndexMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	for id, q := range qrys {
+		if err := index.Index(p.IndexQuery(id, q)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, tt := range tests {
+		col := collector.NewTopNCollector(10, 0, search.SortOrder{})
+		if _, err := index.Search(p.BuildQuery(tt.doc), col); err != nil {
+			t.Fatal(err)
+		}
+
+		got := make([]string, len(col.Results()))
+		for j, hit := range col.Results() {
+			got[j] = hit.ID
+		}
+		sort.Strings(got)
+
+		if len(got) != len(tt.want) {
+			t.Fatalf("%d: expected candidates %v; got %v", i, tt.want, got)
+		}
+		for j := range got {
+			if got[j] != tt.want[j] {
+				t.Fatalf("%d: expected candidates %v; got %v", i, tt.want, got)
+			}
+		}
+	}
+}
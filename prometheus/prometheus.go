@@ -0,0 +1,100 @@
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+// Handler returns an http.Handler serving the registry in the Prometheus text format.
+func Handler(r metrics.Registry, namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		if err := Write(w, r, namespace); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Write writes the registry to w in the Prometheus text format.
+func Write(w io.Writer, r metrics.Registry, namespace string) error {
+	all := map[string]interface{}{}
+	r.Each(func(name string, i interface{}) {
+		all[name] = i
+	})
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		writeMetric(bw, metricName(namespace, name), all[name])
+	}
+
+	return bw.Flush()
+}
+
+func writeMetric(w io.Writer, name string, i interface{}) {
+	switch m := i.(type) {
+	case metrics.Counter:
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, m.Count())
+
+	case metrics.Gauge:
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", name, name, m.Value())
+
+	case metrics.GaugeFloat64:
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %g\n", name, name, m.Value())
+
+	case metrics.Meter:
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", name, name, m.Count())
+
+	case metrics.Histogram:
+		s := m.Snapshot()
+		writeSummary(w, name, s.Percentiles(quantiles), float64(s.Sum()), s.Count())
+
+	case metrics.Timer:
+		s := m.Snapshot()
+		ps := s.Percentiles(quantiles)
+		for i := range ps {
+			ps[i] /= 1e9
+		}
+		writeSummary(w, name+"_seconds", ps, float64(s.Sum())/1e9, s.Count())
+	}
+}
+
+func writeSummary(w io.Writer, name string, ps []float64, sum float64, count int64) {
+	fmt.Fprintf(w, "# TYPE %s summary\n", name)
+	for i, q := range quantiles {
+		fmt.Fprintf(w, "%s{quantile=\"%g\"} %g\n", name, q, ps[i])
+	}
+	fmt.Fprintf(w, "%s_sum %g\n%s_count %d\n", name, sum, name, count)
+}
+
+// metricName converts a go-metrics name into a Prometheus metric name.
+func metricName(namespace, name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == ':':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + "_" + name
+}
@@ -0,0 +1,35 @@
+package prometheus_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo/prometheus"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestWrite(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter("match.errors", r).Inc(2)
+	metrics.GetOrRegisterGauge("match.queries", r).Update(10)
+	metrics.GetOrRegisterTimer("match.latency", r).Update(time.Second)
+
+	buf := &bytes.Buffer{}
+	if err := prometheus.Write(buf, r, "isenzo"); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE isenzo_match_errors counter\nisenzo_match_errors 2\n",
+		"# TYPE isenzo_match_queries gauge\nisenzo_match_queries 10\n",
+		"isenzo_match_latency_seconds_count 1\n",
+		"isenzo_match_latency_seconds{quantile=\"0.5\"} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected output to contain %q; got %s", want, out)
+		}
+	}
+}
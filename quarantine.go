@@ -0,0 +1,204 @@
+package isenzo
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrorQueryNotFound = errors.New("query not found")
+)
+
+// QuarantineEvent represents a query being quarantined or reinstated.
+type QuarantineEvent struct {
+	Namespace  string
+	Id         string
+	Err        error
+	Time       time.Time
+	Reinstated bool
+}
+
+// QuarantinedQuery represents a query disabled by the quarantine policy.
+type QuarantinedQuery struct {
+	Namespace string    `json:"namespace,omitempty"`
+	Id        string    `json:"id"`
+	Err       error     `json:"-"`
+	Since     time.Time `json:"since"`
+}
+
+// breaker tracks the failures of a query within the quarantine window.
+type breaker struct {
+	quarantined int32
+
+	mu       sync.Mutex
+	failures []time.Time
+	err      error
+	since    time.Time
+}
+
+// fail records a failure, returning true if the query should be quarantined.
+func (b *breaker) fail(now time.Time, err error, window time.Duration, max int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cutoff := now.Add(-window)
+	i := 0
+	for i < len(b.failures) && b.failures[i].Before(cutoff) {
+		i++
+	}
+	b.failures = append(b.failures[i:], now)
+
+	if len(b.failures) < max || !atomic.CompareAndSwapInt32(&b.quarantined, 0, 1) {
+		return false
+	}
+
+	b.failures = nil
+	b.err = err
+	b.since = now
+	return true
+}
+
+// reset reinstates the query.
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	atomic.StoreInt32(&b.quarantined, 0)
+	b.failures = nil
+	b.err = nil
+	b.since = time.Time{}
+}
+
+// isQuarantined determines if the query is quarantined.
+func (b *breaker) isQuarantined() bool {
+	return atomic.LoadInt32(&b.quarantined) == 1
+}
+
+// checkQuarantine records a failed query evaluation, quarantining the query
+// when the policy is exceeded.
+//
+// It is called during Match while the cache read lock is held.
+func (p *Percolator) checkQuarantine(c *cachedQuery, err error) {
+	if p.quarantineFailures <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if !c.breaker.fail(now, err, p.quarantineWindow, p.quarantineFailures) {
+		return
+	}
+
+	// Quarantined queries are no longer presearch candidates. The query is
+	// still skipped by Match should removing it fail.
+	if p.presearcher != nil {
+		p.queryIndex.Delete(key(c.namespace, c.query.Id))
+	}
+
+	p.emitQuarantine(QuarantineEvent{Namespace: c.namespace, Id: c.query.Id, Err: err, Time: now})
+}
+
+// emitQuarantine queues the event for the quarantine handler, if any.
+func (p *Percolator) emitQuarantine(ev QuarantineEvent) {
+	if p.quarantineHandler == nil {
+		return
+	}
+
+	p.quarantineLock.Lock()
+	p.quarantineEvents = append(p.quarantineEvents, ev)
+	p.quarantineLock.Unlock()
+
+	select {
+	case p.quarantineSignal <- struct{}{}:
+	default:
+	}
+}
+
+// deliverQuarantine calls the quarantine handler with the queued events, in
+// order, until the Percolator is done.
+//
+// The handler runs outside of Match, so it may call back into the Percolator.
+func (p *Percolator) deliverQuarantine() {
+	defer p.background.Done()
+
+	for {
+		select {
+		case <-p.quarantineSignal:
+		case <-p.done:
+			return
+		}
+
+		p.quarantineLock.Lock()
+		evs := p.quarantineEvents
+		p.quarantineEvents = nil
+		p.quarantineLock.Unlock()
+
+		for _, ev := range evs {
+			p.quarantineHandler(ev)
+		}
+	}
+}
+
+// Quarantined returns the quarantined queries in all namespaces, ordered by namespace and id.
+func (p *Percolator) Quarantined() []QuarantinedQuery {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	var qrys []QuarantinedQuery
+	for _, c := range p.cache {
+		if !c.breaker.isQuarantined() {
+			continue
+		}
+
+		c.breaker.mu.Lock()
+		qrys = append(qrys, QuarantinedQuery{
+			Namespace: c.namespace,
+			Id:        c.query.Id,
+			Err:       c.breaker.err,
+			Since:     c.breaker.since,
+		})
+		c.breaker.mu.Unlock()
+	}
+
+	sort.Slice(qrys, func(i, j int) bool {
+		if qrys[i].Namespace != qrys[j].Namespace {
+			return qrys[i].Namespace < qrys[j].Namespace
+		}
+		return qrys[i].Id < qrys[j].Id
+	})
+
+	return qrys
+}
+
+// Reinstate reinstates a quarantined query.
+func (p *Percolator) Reinstate(id string) error {
+	return p.reinstate(DefaultNamespace, id)
+}
+
+// reinstate reinstates a quarantined query in a namespace.
+func (p *Percolator) reinstate(ns, id string) error {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	c, ok := p.cache[key(ns, id)]
+	if !ok {
+		return ErrorQueryNotFound
+	}
+
+	if !c.breaker.isQuarantined() {
+		return nil
+	}
+
+	if p.presearcher != nil && c.presearch != nil {
+		if err := p.queryIndex.Index(c.presearch); err != nil {
+			return err
+		}
+	}
+
+	c.breaker.reset()
+	p.emitQuarantine(QuarantineEvent{Namespace: ns, Id: id, Time: time.Now(), Reinstated: true})
+
+	return nil
+}
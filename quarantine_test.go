@@ -0,0 +1,149 @@
+package isenzo_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+func TestPercolator_Quarantine(t *testing.T) {
+	events := make(chan isenzo.QuarantineEvent, 2)
+	p, err := isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(&failingFactory{Factory: matchers.NewIndexMatcherFactory(bleve.NewIndexMapping())}),
+		isenzo.WithQuarantine(2, time.Minute, func(ev isenzo.QuarantineEvent) {
+			events <- ev
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	data := map[string]interface{}{"foo": "bar"}
+	for i := 0; i < 2; i++ {
+		if _, err := p.Match(data); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Id != "1" || ev.Reinstated {
+			t.Fatalf("expected quarantine event for 1; got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected quarantine event; got none")
+	}
+
+	if q := p.Quarantined(); len(q) != 1 || q[0].Id != "1" {
+		t.Fatalf("expected quarantined [1]; got %v", q)
+	}
+
+	results, err := p.Match(data)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if results.QueriesRun != 0 {
+		t.Fatalf("expected %d queries run; got %d", 0, results.QueriesRun)
+	}
+
+	if err := p.Reinstate("1"); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if q := p.Quarantined(); len(q) != 0 {
+		t.Fatalf("expected no quarantined queries; got %v", q)
+	}
+
+	if err := p.Reinstate("2"); err != isenzo.ErrorQueryNotFound {
+		t.Fatalf("expected error query not found; got %v", err)
+	}
+}
+
+func TestPercolator_QuarantineEventOrder(t *testing.T) {
+	events := make(chan isenzo.QuarantineEvent, 10)
+	p, err := isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(&failingFactory{Factory: matchers.NewIndexMatcherFactory(bleve.NewIndexMapping())}),
+		isenzo.WithQuarantine(1, time.Minute, func(ev isenzo.QuarantineEvent) {
+			events <- ev
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if err := p.Reinstate("1"); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+
+	for i := 0; i < 6; i++ {
+		select {
+		case ev := <-events:
+			if want := i%2 == 1; ev.Reinstated != want {
+				t.Fatalf("%d: expected reinstated %v; got %+v", i, want, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%d: expected quarantine event; got none", i)
+		}
+	}
+}
+
+// failingFactory creates matchers failing every query.
+type failingFactory struct {
+	matchers.Factory
+
+	observer matchers.Observer
+}
+
+func (f *failingFactory) Observe(o matchers.Observer) error {
+	f.observer = o
+	return nil
+}
+
+func (f *failingFactory) New(doc interface{}) (matchers.Matcher, error) {
+	doc, err := f.Map(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingMatcher{doc: doc, observer: f.observer}, nil
+}
+
+type failingMatcher struct {
+	doc      interface{}
+	observer matchers.Observer
+	errs     []error
+}
+
+func (m *failingMatcher) Match(id string, q query.Query) {
+	err := errors.New("failed")
+	m.observer(m.doc, id, 0, false, err)
+	m.errs = append(m.errs, &matchers.QueryError{Id: id, Err: err})
+}
+
+func (m *failingMatcher) Finish() ([]string, []error) {
+	return []string{}, m.errs
+}
@@ -1,9 +1,25 @@
 package isenzo
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 // Query represents a percolator rule, consisting of a query and a set of changes.
 type Query struct {
-	Id    string
-	Query string
+	Id    string `json:"id"`
+	Query string `json:"query"`
+
+	// Author is the author of the query, kept in the query history.
+	Author string `json:"author,omitempty"`
+
+	// ActiveFrom is the time from which the query is active, if set.
+	ActiveFrom time.Time `json:"active_from,omitempty"`
+	// ExpiresAt is the time at which the query expires, if set.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// Schedule is the recurring window in which the query is active, if set.
+	Schedule *Schedule `json:"schedule,omitempty"`
 }
 
 // NewQuery creates a new Query.
@@ -13,3 +29,102 @@ func NewQuery(id, query string) Query {
 		Query: query,
 	}
 }
+
+// MarshalJSON marshals the query, omitting unset times, which omitempty
+// does not do for time.Time.
+func (q Query) MarshalJSON() ([]byte, error) {
+	type plain Query
+
+	v := struct {
+		plain
+		ActiveFrom *time.Time `json:"active_from,omitempty"`
+		ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	}{plain: plain(q)}
+
+	if !q.ActiveFrom.IsZero() {
+		v.ActiveFrom = &q.ActiveFrom
+	}
+	if !q.ExpiresAt.IsZero() {
+		v.ExpiresAt = &q.ExpiresAt
+	}
+
+	return json.Marshal(v)
+}
+
+// Schedule represents a recurring daily activation window.
+//
+// Start and End are times of day in the form "15:04". A window where End is
+// before Start spans midnight, and a window where End equals Start spans the
+// whole day. If Days is empty, the window applies to every day.
+type Schedule struct {
+	Days     []time.Weekday `json:"days,omitempty"`
+	Start    string         `json:"start"`
+	End      string         `json:"end"`
+	Timezone string         `json:"timezone,omitempty"`
+}
+
+// schedule is a parsed Schedule.
+type schedule struct {
+	days  uint8
+	start time.Duration
+	end   time.Duration
+	loc   *time.Location
+}
+
+func parseSchedule(s *Schedule) (*schedule, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	sch := &schedule{loc: time.UTC}
+	for _, d := range s.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return nil, fmt.Errorf("invalid schedule day %d", d)
+		}
+		sch.days |= 1 << uint(d)
+	}
+
+	var err error
+	if sch.start, err = parseTimeOfDay(s.Start); err != nil {
+		return nil, err
+	}
+	if sch.end, err = parseTimeOfDay(s.End); err != nil {
+		return nil, err
+	}
+
+	if s.Timezone != "" {
+		if sch.loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, err
+		}
+	}
+
+	return sch, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schedule time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// active determines if the time falls within the schedule.
+func (s *schedule) active(t time.Time) bool {
+	t = t.In(s.loc)
+	if s.days != 0 && s.days&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+
+	if s.start == s.end {
+		return true
+	}
+
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if s.start < s.end {
+		return tod >= s.start && tod < s.end
+	}
+
+	return tod >= s.start || tod < s.end
+}
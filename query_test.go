@@ -0,0 +1,165 @@
+package isenzo_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_MatchActiveQueries(t *testing.T) {
+	now := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC) // Monday
+	p, err := isenzo.NewPercolator(isenzo.WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	future := isenzo.NewQuery("future", "foo:bar")
+	future.ActiveFrom = now.Add(time.Hour)
+
+	expired := isenzo.NewQuery("expired", "foo:bar")
+	expired.ExpiresAt = now
+
+	weekdays := isenzo.NewQuery("weekdays", "foo:bar")
+	weekdays.Schedule = &isenzo.Schedule{
+		Days:  []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Start: "09:00",
+		End:   "17:00",
+	}
+
+	night := isenzo.NewQuery("night", "foo:bar")
+	night.Schedule = &isenzo.Schedule{Start: "22:00", End: "06:00"}
+
+	monday := isenzo.NewQuery("monday", "foo:bar")
+	monday.Schedule = &isenzo.Schedule{Days: []time.Weekday{time.Monday}, Start: "12:00", End: "12:00"}
+
+	sunday := isenzo.NewQuery("sunday", "foo:bar")
+	sunday.Schedule = &isenzo.Schedule{Days: []time.Weekday{time.Sunday}, Start: "00:00", End: "00:00"}
+
+	if err := p.Update([]isenzo.Query{future, expired, weekdays, night, monday, sunday}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	results, err := p.Match(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	sort.Strings(results.Ids)
+	if len(results.Ids) != 2 || results.Ids[0] != "monday" || results.Ids[1] != "weekdays" {
+		t.Fatalf("expected results [monday weekdays]; got %v", results.Ids)
+	}
+}
+
+func TestPercolator_UpdateWithInvalidSchedule(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	qry := isenzo.NewQuery("1", "foo:bar")
+	qry.Schedule = &isenzo.Schedule{Start: "9am", End: "17:00"}
+
+	if err := p.Update([]isenzo.Query{qry}); err == nil {
+		t.Fatal("expected errors; got none")
+	}
+}
+
+func TestPercolator_RemoveExpired(t *testing.T) {
+	now := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+	p, err := isenzo.NewPercolator(isenzo.WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	expired := isenzo.NewQuery("1", "foo:bar")
+	expired.ExpiresAt = now.Add(-time.Minute)
+
+	if err := p.Update([]isenzo.Query{expired, isenzo.NewQuery("2", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	ns, err := p.Namespace("a")
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if err := ns.Update([]isenzo.Query{expired}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	refs, err := p.RemoveExpired()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	want := []isenzo.QueryRef{{Namespace: isenzo.DefaultNamespace, Id: "1"}, {Namespace: "a", Id: "1"}}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("expected removed %v; got %v", want, refs)
+	}
+
+	if qrys := p.Queries(); len(qrys) != 1 {
+		t.Fatalf("expected %d queries; got %d", 1, len(qrys))
+	}
+}
+
+func TestPercolator_Janitor(t *testing.T) {
+	now := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+	p, err := isenzo.NewPercolator(
+		isenzo.WithClock(func() time.Time { return now }),
+		isenzo.WithJanitor(time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	expired := isenzo.NewQuery("1", "foo:bar")
+	expired.ExpiresAt = now.Add(-time.Minute)
+
+	if err := p.Update([]isenzo.Query{expired}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.Queries()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected janitor to remove expired queries")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+}
+
+func TestQuery_MarshalJSON(t *testing.T) {
+	b, err := json.Marshal(isenzo.NewQuery("1", "foo:bar"))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if got := string(b); got != `{"id":"1","query":"foo:bar"}` {
+		t.Fatalf("expected unset times to be omitted; got %s", got)
+	}
+
+	qry := isenzo.NewQuery("1", "foo:bar")
+	qry.ExpiresAt = time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+
+	b, err = json.Marshal(qry)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	var got isenzo.Query
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if !reflect.DeepEqual(got, qry) {
+		t.Fatalf("expected %+v; got %+v", qry, got)
+	}
+}
@@ -0,0 +1,249 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+// ErrorResync is reported when the follower's revision is no longer in the
+// leader's change log, or the leader restarted.
+var ErrorResync = errors.New("follower must resynchronise from a leader snapshot")
+
+type optionsFunc func(*Follower)
+
+// WithHTTPClient sets the http client used to reach the leader on the Follower.
+func WithHTTPClient(c *http.Client) optionsFunc {
+	return func(f *Follower) {
+		f.client = c
+	}
+}
+
+// WithRetryInterval sets the time the Follower waits before reconnecting to the leader.
+func WithRetryInterval(d time.Duration) optionsFunc {
+	return func(f *Follower) {
+		f.retry = d
+	}
+}
+
+// Status represents the replication status of a follower.
+type Status struct {
+	Connected bool `json:"connected"`
+	// Revision is the leader revision the follower has applied.
+	Revision uint64 `json:"revision"`
+	// LeaderRevision is the latest revision reported by the leader.
+	LeaderRevision uint64 `json:"leader_revision"`
+	// Lag is the number of leader changes not yet applied.
+	Lag         uint64    `json:"lag"`
+	LastContact time.Time `json:"last_contact"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Follower replicates the queries of a leader into a Percolator.
+//
+// The follower restores a snapshot from the leader and then applies the
+// leader's changes as they happen. Queries should only be written on the
+// leader, local writes on the follower are overwritten on the next resync.
+type Follower struct {
+	p      *isenzo.Percolator
+	leader string
+	client *http.Client
+	retry  time.Duration
+
+	synced bool
+	epoch  string
+
+	mu     sync.Mutex
+	status Status
+}
+
+// NewFollower creates a new Follower of the leader at the given base url.
+func NewFollower(p *isenzo.Percolator, leader string, opts ...optionsFunc) *Follower {
+	f := &Follower{
+		p:      p,
+		leader: strings.TrimSuffix(leader, "/"),
+		client: http.DefaultClient,
+		retry:  time.Second,
+	}
+
+	for _, o := range opts {
+		o(f)
+	}
+
+	return f
+}
+
+// Status returns the replication status of the follower.
+func (f *Follower) Status() Status {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.status
+}
+
+// Run replicates from the leader until the context is done, reconnecting
+// when the connection fails.
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.sync(ctx)
+
+		f.mu.Lock()
+		f.status.Connected = false
+		if err != nil && ctx.Err() == nil {
+			f.status.LastError = err.Error()
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(f.retry):
+		}
+	}
+}
+
+// sync restores a snapshot if needed and tails the leader's changes.
+func (f *Follower) sync(ctx context.Context) error {
+	if !f.synced {
+		if err := f.restore(ctx); err != nil {
+			return err
+		}
+	}
+
+	return f.tail(ctx)
+}
+
+// restore replaces the queries with a snapshot from the leader.
+func (f *Follower) restore(ctx context.Context) error {
+	resp, err := f.get(ctx, "/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected snapshot status %d from leader", resp.StatusCode)
+	}
+
+	rev, err := f.p.Restore(resp.Body)
+	if err != nil {
+		return err
+	}
+	f.synced = true
+	f.epoch = resp.Header.Get(EpochHeader)
+
+	f.mu.Lock()
+	f.status.Revision = rev
+	f.updateLocked(rev)
+	f.mu.Unlock()
+
+	return nil
+}
+
+// tail applies the leader's changes after the applied revision.
+func (f *Follower) tail(ctx context.Context) error {
+	f.mu.Lock()
+	rev := f.status.Revision
+	f.mu.Unlock()
+
+	resp, err := f.get(ctx, "/changes?from="+strconv.FormatUint(rev, 10))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		f.synced = false
+		return ErrorResync
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected changes status %d from leader", resp.StatusCode)
+	}
+	if resp.Header.Get(EpochHeader) != f.epoch {
+		// The leader restarted, its revisions are unrelated to ours.
+		f.synced = false
+		return ErrorResync
+	}
+
+	f.mu.Lock()
+	f.status.Connected = true
+	f.status.LastError = ""
+	f.mu.Unlock()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var m Message
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+
+		if m.Epoch != f.epoch || m.Revision < rev {
+			// The leader restarted with a new change log.
+			f.synced = false
+			return ErrorResync
+		}
+
+		if m.Change != nil {
+			if err := f.apply(m.Change); err != nil {
+				// The change cannot be skipped, start over from a snapshot.
+				f.synced = false
+				return err
+			}
+		}
+
+		f.mu.Lock()
+		if m.Change != nil {
+			rev = m.Change.Revision
+			f.status.Revision = rev
+		}
+		f.updateLocked(m.Revision)
+		f.mu.Unlock()
+	}
+}
+
+// apply applies a leader change to the Percolator.
+func (f *Follower) apply(c *isenzo.Change) error {
+	ns, err := f.p.Namespace(c.Namespace)
+	if err != nil {
+		return err
+	}
+
+	switch c.Type {
+	case isenzo.ChangeAdded, isenzo.ChangeUpdated:
+		return ns.Update([]isenzo.Query{c.Query})
+
+	case isenzo.ChangeDeleted:
+		return ns.Delete([]string{c.Id})
+	}
+
+	return fmt.Errorf("unknown change type %q", c.Type)
+}
+
+// updateLocked records contact with the leader at the given revision. The
+// lock must be held.
+func (f *Follower) updateLocked(leaderRev uint64) {
+	f.status.LeaderRevision = leaderRev
+	f.status.Lag = 0
+	if f.status.LeaderRevision > f.status.Revision {
+		f.status.Lag = f.status.LeaderRevision - f.status.Revision
+	}
+	f.status.LastContact = time.Now()
+}
+
+// get requests a path on the leader.
+func (f *Follower) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, f.leader+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.client.Do(req.WithContext(ctx))
+}
@@ -0,0 +1,207 @@
+package replication_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/replication"
+)
+
+func TestFollower(t *testing.T) {
+	leader := newPercolator(t)
+	if err := leader.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	srv := httptest.NewServer(replication.NewLeader(leader, 10*time.Millisecond))
+	defer srv.Close()
+
+	follower := newPercolator(t)
+	f := replication.NewFollower(follower, srv.URL, replication.WithRetryInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	waitFor(t, func() bool {
+		_, ok := follower.Query("1")
+		return ok
+	})
+
+	ns, err := leader.Namespace("tenant")
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	ns.Update([]isenzo.Query{isenzo.NewQuery("2", "foo:bar")})
+	leader.Update([]isenzo.Query{isenzo.NewQuery("1", "baz:bat")})
+	leader.Delete([]string{"1"})
+	leader.Update([]isenzo.Query{isenzo.NewQuery("3", "foo:bar")})
+
+	waitFor(t, func() bool {
+		return f.Status().Revision == leader.Revision()
+	})
+
+	if qrys := follower.Queries(); len(qrys) != 1 || qrys[0].Id != "3" {
+		t.Fatalf("expected queries [3]; got %v", qrys)
+	}
+
+	fns, _ := follower.Namespace("tenant")
+	if qrys := fns.Queries(); len(qrys) != 1 || qrys[0].Id != "2" {
+		t.Fatalf("expected namespace queries [2]; got %v", qrys)
+	}
+
+	res, err := follower.Match(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if len(res.Ids) != 1 || res.Ids[0] != "3" {
+		t.Fatalf("expected results [3]; got %v", res.Ids)
+	}
+
+	status := f.Status()
+	if !status.Connected || status.Lag != 0 || status.LeaderRevision != leader.Revision() {
+		t.Fatalf("expected connected follower without lag; got %+v", status)
+	}
+}
+
+func TestFollower_Resync(t *testing.T) {
+	leader, err := isenzo.NewPercolator(isenzo.WithChangeLog(2))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	srv := httptest.NewServer(replication.NewLeader(leader, 10*time.Millisecond))
+	defer srv.Close()
+
+	follower := newPercolator(t)
+	f := replication.NewFollower(follower, srv.URL, replication.WithRetryInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	waitFor(t, func() bool { return f.Status().Connected })
+	cancel()
+	<-done
+
+	// Compact the follower's revision out of the leader's change log.
+	for i := 0; i < 5; i++ {
+		leader.Update([]isenzo.Query{isenzo.NewQuery(strconv.Itoa(i), "foo:bar")})
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	waitFor(t, func() bool {
+		return len(follower.Queries()) == 5 && f.Status().Revision == leader.Revision()
+	})
+}
+
+func TestFollower_LeaderRestart(t *testing.T) {
+	leader := newPercolator(t)
+	if err := leader.Update([]isenzo.Query{isenzo.NewQuery("a", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	l := replication.NewLeader(leader, 10*time.Millisecond)
+	h := &swapHandler{h: l}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	follower := newPercolator(t)
+	f := replication.NewFollower(follower, srv.URL, replication.WithRetryInterval(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	waitFor(t, func() bool {
+		return f.Status().Connected && f.Status().Revision == leader.Revision()
+	})
+
+	// The restarted leader has passed the follower's revision with other changes.
+	restarted := newPercolator(t)
+	for _, id := range []string{"b", "c", "d"} {
+		restarted.Update([]isenzo.Query{isenzo.NewQuery(id, "foo:bar")})
+	}
+	h.set(replication.NewLeader(restarted, 10*time.Millisecond))
+	l.Close()
+
+	waitFor(t, func() bool {
+		qrys := follower.Queries()
+		return len(qrys) == 3 && qrys[0].Id == "b" && f.Status().Revision == restarted.Revision()
+	})
+}
+
+func TestLeader_ChangesGone(t *testing.T) {
+	leader, err := isenzo.NewPercolator(isenzo.WithChangeLog(1))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		leader.Update([]isenzo.Query{isenzo.NewQuery(strconv.Itoa(i), "foo:bar")})
+	}
+
+	srv := httptest.NewServer(replication.NewLeader(leader, time.Second))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/changes?from=0")
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("expected status %d; got %d", http.StatusGone, resp.StatusCode)
+	}
+}
+
+func newPercolator(t *testing.T) *isenzo.Percolator {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	return p
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	for i := 0; i < 200; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("timed out waiting for replication")
+}
+
+// swapHandler serves with a handler that can be replaced, like a restarted leader.
+type swapHandler struct {
+	mu sync.Mutex
+	h  http.Handler
+}
+
+func (s *swapHandler) set(h http.Handler) {
+	s.mu.Lock()
+	s.h = h
+	s.mu.Unlock()
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	h := s.h
+	s.mu.Unlock()
+
+	h.ServeHTTP(w, r)
+}
@@ -0,0 +1,182 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+// EpochHeader is the http header holding the epoch of the leader a snapshot
+// or change stream is served by.
+const EpochHeader = "X-Isenzo-Epoch"
+
+// Message represents a message on the change stream of a leader.
+type Message struct {
+	// Epoch identifies the leader instance, as revisions restart with it.
+	Epoch string `json:"epoch"`
+	// Revision is the latest change revision of the leader.
+	Revision uint64 `json:"revision"`
+	// Change is the next change, or nil for a heartbeat.
+	Change *isenzo.Change `json:"change,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Leader represents the http handler followers replicate a Percolator from.
+//
+// It serves a snapshot of the queries on /snapshot, and streams the changes
+// after a revision as JSON lines on /changes?from=<revision>, answering
+// 410 Gone if the revision is no longer in the change log. Both carry the
+// epoch of the leader, which changes when the leader is restarted.
+type Leader struct {
+	p         *isenzo.Percolator
+	mux       *http.ServeMux
+	heartbeat time.Duration
+	epoch     string
+
+	done chan struct{}
+	once sync.Once
+}
+
+// NewLeader creates a new Leader, sending a heartbeat with the latest
+// revision on idle change streams at the given interval.
+func NewLeader(p *isenzo.Percolator, heartbeat time.Duration) *Leader {
+	l := &Leader{
+		p:         p,
+		mux:       http.NewServeMux(),
+		heartbeat: heartbeat,
+		epoch:     newEpoch(),
+		done:      make(chan struct{}),
+	}
+
+	l.mux.HandleFunc("/snapshot", l.handleSnapshot)
+	l.mux.HandleFunc("/changes", l.handleChanges)
+
+	return l
+}
+
+// newEpoch returns a random leader epoch.
+func newEpoch() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Epoch returns the epoch of the leader.
+func (l *Leader) Epoch() string {
+	return l.epoch
+}
+
+// Close ends all change streams, so the http server can shut down.
+func (l *Leader) Close() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+// ServeHTTP serves an http request.
+func (l *Leader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mux.ServeHTTP(w, r)
+}
+
+func (l *Leader) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(EpochHeader, l.epoch)
+	l.p.Export(w)
+}
+
+func (l *Leader) handleChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var from uint64
+	if s := r.URL.Query().Get("from"); s != "" {
+		var err error
+		if from, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid revision")
+			return
+		}
+	}
+
+	watcher, err := l.p.Watch(from)
+	if err == isenzo.ErrorRevisionCompacted {
+		writeError(w, http.StatusGone, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(EpochHeader, l.epoch)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	send := func(m Message) bool {
+		m.Epoch = l.epoch
+		if err := enc.Encode(m); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	if !send(Message{Revision: l.p.Revision()}) {
+		return
+	}
+
+	ticker := time.NewTicker(l.heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case c, ok := <-watcher.Changes():
+			// The watcher is closed when it falls behind, the follower
+			// reconnects and is told to resynchronise.
+			if !ok || !send(Message{Revision: l.p.Revision(), Change: &c}) {
+				return
+			}
+
+		case <-ticker.C:
+			if !send(Message{Revision: l.p.Revision()}) {
+				return
+			}
+
+		case <-r.Context().Done():
+			return
+
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(errorResponse{Error: msg})
+}
@@ -8,4 +8,10 @@ type Results struct {
 	Errs       []error
 	Took       time.Duration
 	QueriesRun int
+
+	// Retroactive is set on the results of added or changed queries matched
+	// against a recently matched document, which is held in Document.
+	Retroactive bool
+	Namespace   string
+	Document    interface{}
 }
@@ -0,0 +1,188 @@
+package isenzo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+// retroactQueueSize is the number of updates waiting to be matched against
+// the recent documents.
+const retroactQueueSize = 64
+
+// recentDoc is a document recently matched against the namespaces.
+type recentDoc struct {
+	doc        interface{}
+	namespaces []string
+	time       time.Time
+}
+
+// retroactTask is an update to match against the recent documents.
+type retroactTask struct {
+	ns   string
+	docs []recentDoc
+	qrys map[string]*cachedQuery
+}
+
+// recentDocs is a buffer of recently matched documents, bounded by count and age.
+type recentDocs struct {
+	size   int
+	window time.Duration
+	queue  chan retroactTask
+
+	mu   sync.Mutex
+	docs []recentDoc
+}
+
+// add adds a matched document to the buffer, evicting the documents
+// exceeding its size or older than its window.
+//
+// The document is held by the buffer, while the namespaces are copied.
+func (b *recentDocs) add(doc interface{}, namespaces []string, now time.Time) {
+	namespaces = append([]string{}, namespaces...)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.docs = append(b.docs, recentDoc{doc: doc, namespaces: namespaces, time: now})
+	b.evict(now)
+}
+
+// evict removes the documents exceeding the buffer size or older than its
+// window. The buffer lock must be held.
+func (b *recentDocs) evict(now time.Time) {
+	n := 0
+	for n < len(b.docs) {
+		if len(b.docs)-n <= b.size && (b.window <= 0 || now.Sub(b.docs[n].time) <= b.window) {
+			break
+		}
+		n++
+	}
+
+	// Clear the evicted documents so they can be collected.
+	for i := 0; i < n; i++ {
+		b.docs[i] = recentDoc{}
+	}
+	b.docs = b.docs[n:]
+}
+
+// snapshot returns the buffered documents matched against the namespace, oldest first.
+func (b *recentDocs) snapshot(ns string, now time.Time) []recentDoc {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.evict(now)
+
+	var docs []recentDoc
+	for _, d := range b.docs {
+		for _, name := range d.namespaces {
+			if name == ns {
+				docs = append(docs, d)
+				break
+			}
+		}
+	}
+
+	return docs
+}
+
+// retroact queues added or changed queries of a namespace to be matched
+// against the recently matched documents, dropping them if the queue is
+// full. The cache lock must be held.
+func (p *Percolator) retroact(ns string, qrys map[string]*cachedQuery) {
+	if p.recent == nil || len(qrys) == 0 {
+		return
+	}
+
+	docs := p.recent.snapshot(ns, p.now())
+	if len(docs) == 0 {
+		return
+	}
+
+	select {
+	case p.recent.queue <- retroactTask{ns: ns, docs: docs, qrys: qrys}:
+	default:
+		p.metrics.retroactiveDropped.Inc(1)
+	}
+}
+
+// matchRetroactive matches the queued updates against the recent documents
+// until the Percolator is done.
+func (p *Percolator) matchRetroactive() {
+	defer p.background.Done()
+
+	for {
+		select {
+		case t := <-p.recent.queue:
+			for _, d := range t.docs {
+				if isClosed(p.done) {
+					return
+				}
+
+				if res := p.matchRecent(t.ns, d, t.qrys); res != nil {
+					p.retroactiveHandler(res)
+				}
+			}
+
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// matchRecent matches the queries that are still current against a recently
+// matched document, returning nil if there are no results.
+func (p *Percolator) matchRecent(ns string, d recentDoc, qrys map[string]*cachedQuery) *Results {
+	start := time.Now()
+
+	// The matcher observer reads the cache, so the lock is held until the
+	// matcher is finished.
+	p.cacheLock.RLock()
+
+	m, err := p.matcher.New(d.doc)
+	if err != nil {
+		p.cacheLock.RUnlock()
+		return &Results{Ids: []string{}, Errs: []error{err}, Retroactive: true, Namespace: ns, Document: d.doc}
+	}
+
+	run := 0
+	for k, c := range qrys {
+		if p.cache[k] != c || !c.active(d.time) {
+			continue
+		}
+
+		run++
+		m.Match(k, c.qry)
+	}
+
+	matched, errs := m.Finish()
+	p.cacheLock.RUnlock()
+
+	if len(matched) == 0 && len(errs) == 0 {
+		return nil
+	}
+
+	res := &Results{
+		Ids:         make([]string, 0, len(matched)),
+		Errs:        make([]error, 0, len(errs)),
+		Took:        time.Since(start),
+		QueriesRun:  run,
+		Retroactive: true,
+		Namespace:   ns,
+		Document:    d.doc,
+	}
+	for _, k := range matched {
+		_, id := splitKey(k)
+		res.Ids = append(res.Ids, id)
+	}
+	for _, err := range errs {
+		if qerr, ok := err.(*matchers.QueryError); ok {
+			_, id := splitKey(qerr.Id)
+			err = &matchers.QueryError{Id: id, Err: qerr.Err}
+		}
+		res.Errs = append(res.Errs, err)
+	}
+
+	return res
+}
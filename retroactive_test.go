@@ -0,0 +1,179 @@
+package isenzo_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_Retroactive(t *testing.T) {
+	results := make(chan *isenzo.Results, 10)
+	p, err := isenzo.NewPercolator(isenzo.WithRetroactive(10, 0, func(res *isenzo.Results) {
+		results <- res
+	}))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	for _, v := range []string{"bar", "baz"} {
+		if _, err := p.Match(map[string]interface{}{"foo": v}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	res := receiveResults(t, results)
+	if !res.Retroactive || !reflect.DeepEqual(res.Ids, []string{"1"}) {
+		t.Fatalf("expected retroactive results [1]; got %+v", res)
+	}
+
+	if doc := res.Document.(map[string]interface{}); doc["foo"] != "bar" {
+		t.Fatalf("expected document foo:bar; got %v", doc)
+	}
+
+	// Unchanged queries are not matched again.
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), isenzo.NewQuery("2", "foo:baz")})
+
+	res = receiveResults(t, results)
+	if !reflect.DeepEqual(res.Ids, []string{"2"}) {
+		t.Fatalf("expected retroactive results [2]; got %v", res.Ids)
+	}
+
+	select {
+	case res := <-results:
+		t.Fatalf("expected no more results; got %+v", res)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPercolator_RetroactiveBuffer(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	results := make(chan *isenzo.Results, 10)
+	p, err := isenzo.NewPercolator(
+		isenzo.WithClock(func() time.Time { return now }),
+		isenzo.WithRetroactive(2, time.Minute, func(res *isenzo.Results) {
+			results <- res
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	for _, v := range []string{"bar", "baz", "qux"} {
+		if _, err := p.Match(map[string]interface{}{"foo": v}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+		now = now.Add(45 * time.Second)
+	}
+
+	// Only the last document is within both the size and the window.
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar foo:baz foo:qux")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	res := receiveResults(t, results)
+	if doc := res.Document.(map[string]interface{}); doc["foo"] != "qux" {
+		t.Fatalf("expected document foo:qux; got %v", doc)
+	}
+
+	select {
+	case res := <-results:
+		t.Fatalf("expected no more results; got %+v", res)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPercolator_RetroactiveNamespaces(t *testing.T) {
+	results := make(chan *isenzo.Results, 10)
+	p, err := isenzo.NewPercolator(isenzo.WithRetroactive(10, 0, func(res *isenzo.Results) {
+		results <- res
+	}))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.MatchNamespaces(map[string]interface{}{"foo": "bar"}, "a", "b"); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	c, _ := p.Namespace("c")
+	c.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")})
+
+	b, _ := p.Namespace("b")
+	b.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")})
+
+	res := receiveResults(t, results)
+	if res.Namespace != "b" || !reflect.DeepEqual(res.Ids, []string{"1"}) {
+		t.Fatalf("expected retroactive results [1] in namespace b; got %+v", res)
+	}
+}
+
+// receiveResults waits for results to be delivered to the channel.
+func TestPercolator_RetroactiveInOrder(t *testing.T) {
+	results := make(chan *isenzo.Results, 10)
+	p, err := isenzo.NewPercolator(isenzo.WithRetroactive(10, 0, func(res *isenzo.Results) {
+		results <- res
+	}))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := p.Update([]isenzo.Query{isenzo.NewQuery(id, "foo:bar")}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		if res := receiveResults(t, results); !reflect.DeepEqual(res.Ids, []string{id}) {
+			t.Fatalf("expected retroactive results [%s]; got %v", id, res.Ids)
+		}
+	}
+}
+
+func TestPercolator_RetroactiveNoSize(t *testing.T) {
+	results := make(chan *isenzo.Results, 10)
+	p, err := isenzo.NewPercolator(isenzo.WithRetroactive(0, time.Minute, func(res *isenzo.Results) {
+		results <- res
+	}))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer p.Close()
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	select {
+	case res := <-results:
+		t.Fatalf("expected retroactive matching to be disabled; got %+v", res)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func receiveResults(t *testing.T, ch chan *isenzo.Results) *isenzo.Results {
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected retroactive results; got none")
+		return nil
+	}
+}
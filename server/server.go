@@ -0,0 +1,316 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+// Results represents the results of a match.
+type Results struct {
+	Ids        []string      `json:"ids"`
+	Errs       []string      `json:"errors"`
+	Took       time.Duration `json:"took"`
+	QueriesRun int           `json:"queries_run"`
+}
+
+func newResults(r *isenzo.Results) Results {
+	errs := make([]string, len(r.Errs))
+	for i, err := range r.Errs {
+		errs[i] = err.Error()
+	}
+
+	return Results{
+		Ids:        r.Ids,
+		Errs:       errs,
+		Took:       r.Took,
+		QueriesRun: r.QueriesRun,
+	}
+}
+
+// Stats represents the statistics of the server.
+type Stats struct {
+	Queries   int           `json:"queries"`
+	Documents int64         `json:"documents"`
+	Matches   int64         `json:"matches"`
+	Errors    int64         `json:"errors"`
+	Uptime    time.Duration `json:"uptime"`
+}
+
+// MaxBodySize is the maximum size of a request body in bytes.
+const MaxBodySize = 10 << 20
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type optionsFunc func(*Server)
+
+// WithReadOnly rejects query writes on the Server, for example when the
+// queries are replicated from a leader.
+func WithReadOnly() optionsFunc {
+	return func(s *Server) {
+		s.readOnly = true
+	}
+}
+
+// Server represents a percolator http server.
+type Server struct {
+	documents int64
+	matches   int64
+	errors    int64
+
+	p        *isenzo.Percolator
+	mux      *http.ServeMux
+	started  time.Time
+	readOnly bool
+}
+
+// New creates a new Server.
+func New(p *isenzo.Percolator, opts ...optionsFunc) *Server {
+	s := &Server{
+		p:       p,
+		mux:     http.NewServeMux(),
+		started: time.Now(),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	s.mux.HandleFunc("/health", s.handleHealth)
+	s.mux.HandleFunc("/stats", s.handleStats)
+	s.mux.HandleFunc("/queries", s.handleQueries)
+	s.mux.HandleFunc("/queries/", s.handleQuery)
+	s.mux.HandleFunc("/percolate", s.handlePercolate)
+	s.mux.HandleFunc("/percolate/batch", s.handlePercolateBatch)
+
+	return s
+}
+
+// ServeHTTP serves an http request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, Stats{
+		Queries:   len(s.p.Queries()),
+		Documents: atomic.LoadInt64(&s.documents),
+		Matches:   atomic.LoadInt64(&s.matches),
+		Errors:    atomic.LoadInt64(&s.errors),
+		Uptime:    time.Since(s.started),
+	})
+}
+
+func (s *Server) handleQueries(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.p.Queries())
+
+	case http.MethodPost, http.MethodPut:
+		if s.readOnly {
+			writeError(w, http.StatusForbidden, "queries are read only")
+			return
+		}
+
+		var qrys []isenzo.Query
+		if err := decode(w, r, &qrys); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		for _, qry := range qrys {
+			if qry.Id == "" {
+				writeError(w, http.StatusBadRequest, "query id cannot be empty")
+				return
+			}
+		}
+
+		if err := s.p.Update(qrys); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, qrys)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/queries/")
+	if id == "" {
+		writeError(w, http.StatusNotFound, "query not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		qry, ok := s.p.Query(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "query not found")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, qry)
+
+	case http.MethodPut:
+		if s.readOnly {
+			writeError(w, http.StatusForbidden, "queries are read only")
+			return
+		}
+
+		var qry isenzo.Query
+		if err := decode(w, r, &qry); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		qry.Id = id
+
+		if err := s.p.Update([]isenzo.Query{qry}); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, qry)
+
+	case http.MethodDelete:
+		if s.readOnly {
+			writeError(w, http.StatusForbidden, "queries are read only")
+			return
+		}
+
+		if _, ok := s.p.Query(id); !ok {
+			writeError(w, http.StatusNotFound, "query not found")
+			return
+		}
+
+		if err := s.p.Delete([]string{id}); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handlePercolate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var doc json.RawMessage
+	if err := decode(w, r, &doc); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	res, err := s.match(doc)
+	if err != nil {
+		writeError(w, matchStatus(err), err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) handlePercolateBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var docs []json.RawMessage
+	if err := decode(w, r, &docs); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results := make([]Results, len(docs))
+	for i, doc := range docs {
+		res, err := s.match(doc)
+		if err != nil {
+			writeError(w, matchStatus(err), err.Error())
+			return
+		}
+
+		results[i] = res
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) match(doc json.RawMessage) (Results, error) {
+	atomic.AddInt64(&s.documents, 1)
+
+	res, err := s.p.Match(doc)
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+		return Results{}, err
+	}
+
+	atomic.AddInt64(&s.matches, int64(len(res.Ids)))
+	atomic.AddInt64(&s.errors, int64(len(res.Errs)))
+
+	return newResults(res), nil
+}
+
+// decode decodes the request body, limited to MaxBodySize, into v.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+
+	if dec.More() {
+		return errors.New("unexpected data after request body")
+	}
+
+	return nil
+}
+
+// matchStatus returns the status code of a match error.
+func matchStatus(err error) int {
+	switch err {
+	case isenzo.ErrorInvalidDocument, isenzo.ErrorTrailingData:
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
@@ -0,0 +1,182 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/server"
+)
+
+func TestServer_Queries(t *testing.T) {
+	srv := newTestServer(t)
+
+	w := doRequest(srv, "POST", "/queries", `[{"id":"1","query":"foo:bar"},{"id":"2","query":"baz"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, w.Code)
+	}
+
+	w = doRequest(srv, "GET", "/queries", "")
+	var qrys []isenzo.Query
+	if err := json.NewDecoder(w.Body).Decode(&qrys); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if len(qrys) != 2 {
+		t.Fatalf("expected %d queries; got %d", 2, len(qrys))
+	}
+
+	w = doRequest(srv, "DELETE", "/queries/2", "")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d; got %d", http.StatusNoContent, w.Code)
+	}
+
+	w = doRequest(srv, "GET", "/queries/2", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestServer_QueriesWithErrors(t *testing.T) {
+	srv := newTestServer(t)
+
+	w := doRequest(srv, "POST", "/queries", `[{"id":"1","query":"foo:bar"},{"id":"2","query":"+-"}]`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d; got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = doRequest(srv, "GET", "/queries/1", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d; got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestServer_QueriesReadOnly(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	srv := server.New(p, server.WithReadOnly())
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{method: "POST", path: "/queries", body: `[{"id":"2","query":"foo:bar"}]`},
+		{method: "PUT", path: "/queries", body: `[{"id":"2","query":"foo:bar"}]`},
+		{method: "PUT", path: "/queries/1", body: `{"query":"baz"}`},
+		{method: "DELETE", path: "/queries/1"},
+	}
+
+	for _, tt := range tests {
+		w := doRequest(srv, tt.method, tt.path, tt.body)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("%s %s: expected status %d; got %d", tt.method, tt.path, http.StatusForbidden, w.Code)
+		}
+	}
+
+	if w := doRequest(srv, "GET", "/queries/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, w.Code)
+	}
+	if qrys := p.Queries(); len(qrys) != 1 || qrys[0].Query != "foo:bar" {
+		t.Fatalf("expected queries to be unchanged; got %v", qrys)
+	}
+}
+
+func TestServer_QueriesTooLarge(t *testing.T) {
+	srv := newTestServer(t)
+
+	body := `[{"id":"1","query":"` + strings.Repeat("a", server.MaxBodySize) + `"}]`
+	w := doRequest(srv, "POST", "/queries", body)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d; got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestServer_Percolate(t *testing.T) {
+	srv := newTestServer(t)
+	doRequest(srv, "POST", "/queries", `[{"id":"1","query":"foo:bar"},{"id":"2","query":"test"}]`)
+
+	w := doRequest(srv, "POST", "/percolate", `{"foo":"bar"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, w.Code)
+	}
+
+	var res server.Results
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if len(res.Ids) != 1 || res.Ids[0] != "1" {
+		t.Fatalf("expected results [1]; got %v", res.Ids)
+	}
+}
+
+func TestServer_PercolateInvalidDocument(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, body := range []string{`[1,2]`, `{"foo":"bar"} {}`, `{"foo":`} {
+		w := doRequest(srv, "POST", "/percolate", body)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status %d for %s; got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+
+	w := doRequest(srv, "POST", "/percolate/batch", `[{"foo":"bar"},[1,2]]`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d; got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestServer_PercolateBatch(t *testing.T) {
+	srv := newTestServer(t)
+	doRequest(srv, "POST", "/queries", `[{"id":"1","query":"foo:bar"}]`)
+
+	w := doRequest(srv, "POST", "/percolate/batch", `[{"foo":"bar"},{"foo":"baz"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, w.Code)
+	}
+
+	var res []server.Results
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if len(res) != 2 {
+		t.Fatalf("expected %d results; got %d", 2, len(res))
+	}
+	if len(res[0].Ids) != 1 || len(res[1].Ids) != 0 {
+		t.Fatalf("expected results [[1] []]; got [%v %v]", res[0].Ids, res[1].Ids)
+	}
+}
+
+func TestServer_Health(t *testing.T) {
+	srv := newTestServer(t)
+
+	w := doRequest(srv, "GET", "/health", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d; got %d", http.StatusOK, w.Code)
+	}
+}
+
+func newTestServer(t *testing.T) *server.Server {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	return server.New(p)
+}
+
+func doRequest(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
@@ -0,0 +1,327 @@
+package isenzo
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultShadowSamples  = 100
+	defaultShadowInterval = time.Minute
+	defaultShadowBuckets  = 60
+)
+
+// shadowKeyPrefix prefixes the matcher keys of shadow queries. Query keys
+// always contain a '/', so shadow keys cannot collide with them. Each staged
+// query has its own key, so matchers can keep state per shadow query.
+const shadowKeyPrefix = "shadow#"
+
+// DisagreementType represents how a shadow query disagreed with its live version.
+type DisagreementType string
+
+// DisagreementType values.
+const (
+	// NewMatch is a document matched by the shadow query but not the live query.
+	NewMatch DisagreementType = "new"
+	// LostMatch is a document matched by the live query but not the shadow query.
+	LostMatch DisagreementType = "lost"
+)
+
+// Disagreement represents a document the shadow and live versions of a query disagreed on.
+type Disagreement struct {
+	Type        DisagreementType `json:"type"`
+	Fingerprint string           `json:"fingerprint"`
+	Document    interface{}      `json:"document,omitempty"`
+	Time        time.Time        `json:"time"`
+}
+
+// ShadowBucket represents the shadow evaluation counts of an interval.
+type ShadowBucket struct {
+	Start       time.Time `json:"start"`
+	Documents   int64     `json:"documents"`
+	NewMatches  int64     `json:"new_matches"`
+	LostMatches int64     `json:"lost_matches"`
+}
+
+// ShadowReport represents the comparison of a shadow query with its live version.
+type ShadowReport struct {
+	Namespace   string    `json:"namespace,omitempty"`
+	Id          string    `json:"id"`
+	Query       Query     `json:"query"`
+	Staged      time.Time `json:"staged"`
+	Documents   int64     `json:"documents"`
+	Matches     int64     `json:"matches"`
+	LiveMatches int64     `json:"live_matches"`
+	NewMatches  int64     `json:"new_matches"`
+	LostMatches int64     `json:"lost_matches"`
+	Errors      int64     `json:"errors"`
+	// Uncompared is the number of documents the live query was not
+	// evaluated on, or failed on, so the matches were not compared.
+	Uncompared    int64          `json:"uncompared"`
+	Buckets       []ShadowBucket `json:"buckets"`
+	Disagreements []Disagreement `json:"disagreements"`
+}
+
+// shadowQuery is a staged query evaluated alongside its live version.
+type shadowQuery struct {
+	key    string
+	c      *cachedQuery
+	staged time.Time
+
+	mu     sync.Mutex
+	report ShadowReport
+}
+
+// observe records the evaluation of the shadow query on a document.
+func (s *shadowQuery) observe(p *Percolator, now time.Time, doc, mapped interface{}, live, uncompared, matched, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &s.report
+	r.Documents++
+	if live {
+		r.LiveMatches++
+	}
+
+	b := s.bucket(p, now)
+	b.Documents++
+
+	// A failed evaluation is not compared, as the shadow result is unknown.
+	if failed {
+		r.Errors++
+		return
+	}
+
+	if matched {
+		r.Matches++
+	}
+
+	// The live result is unknown when the live query was not evaluated.
+	if uncompared {
+		r.Uncompared++
+		return
+	}
+
+	if live == matched {
+		return
+	}
+
+	d := Disagreement{Type: NewMatch, Fingerprint: fingerprint(mapped), Document: doc, Time: now}
+	if live {
+		d.Type = LostMatch
+		r.LostMatches++
+		b.LostMatches++
+	} else {
+		r.NewMatches++
+		b.NewMatches++
+	}
+
+	if p.shadowSamples <= 0 {
+		return
+	}
+	if len(r.Disagreements) >= p.shadowSamples {
+		copy(r.Disagreements, r.Disagreements[1:])
+		r.Disagreements = r.Disagreements[:len(r.Disagreements)-1]
+	}
+	r.Disagreements = append(r.Disagreements, d)
+}
+
+// bucket returns the bucket of the interval containing now, evicting the
+// oldest bucket once full. The shadow lock must be held.
+func (s *shadowQuery) bucket(p *Percolator, now time.Time) *ShadowBucket {
+	r := &s.report
+	if p.shadowBuckets <= 0 {
+		return &ShadowBucket{}
+	}
+
+	start := now.Truncate(p.shadowInterval)
+	if n := len(r.Buckets); n > 0 && !r.Buckets[n-1].Start.Before(start) {
+		return &r.Buckets[n-1]
+	}
+
+	if len(r.Buckets) >= p.shadowBuckets {
+		copy(r.Buckets, r.Buckets[1:])
+		r.Buckets = r.Buckets[:len(r.Buckets)-1]
+	}
+	r.Buckets = append(r.Buckets, ShadowBucket{Start: start})
+
+	return &r.Buckets[len(r.Buckets)-1]
+}
+
+// snapshot returns a copy of the shadow report.
+func (s *shadowQuery) snapshot() ShadowReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.report
+	r.Namespace = s.c.namespace
+	r.Id = s.c.query.Id
+	r.Query = s.c.query
+	r.Staged = s.staged
+	r.Buckets = append([]ShadowBucket{}, s.report.Buckets...)
+	r.Disagreements = append([]Disagreement{}, s.report.Disagreements...)
+
+	return r
+}
+
+// shadowKey returns the matcher key of the n-th staged shadow query.
+func shadowKey(n uint64) string {
+	return shadowKeyPrefix + strconv.FormatUint(n, 10)
+}
+
+// Stage sets shadow versions of queries on the Percolator.
+//
+// Shadow queries are evaluated against every document matched against their
+// namespace, but are not returned in the Results. Their matches are compared
+// with the live query of the same id, if any, in a ShadowReport. Staging a
+// query again resets its report.
+//
+// Shadow queries are not presearched, so each staged query adds a full query
+// evaluation to every match of its namespace. Keep the staged set small and
+// Unstage queries once their report is conclusive.
+func (p *Percolator) Stage(qrys []Query) error {
+	return p.stage(DefaultNamespace, qrys)
+}
+
+// stage sets shadow versions of queries in a namespace.
+func (p *Percolator) stage(ns string, qrys []Query) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
+	shadows := make(map[string]*shadowQuery, len(qrys))
+	for _, qry := range qrys {
+		c, err := p.newCachedQuery(ns, qry)
+		if err != nil {
+			return err
+		}
+
+		shadows[key(ns, qry.Id)] = &shadowQuery{c: c, staged: p.now()}
+	}
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	for k, s := range shadows {
+		if old, ok := p.shadows[k]; ok {
+			p.forget(old.key)
+		}
+
+		p.shadowSeq++
+		s.key = shadowKey(p.shadowSeq)
+		p.shadows[k] = s
+	}
+
+	return nil
+}
+
+// Unstage removes the shadow queries with the given ids from the Percolator.
+func (p *Percolator) Unstage(ids []string) error {
+	return p.unstage(DefaultNamespace, ids)
+}
+
+// unstage removes the shadow queries with the given ids from a namespace.
+func (p *Percolator) unstage(ns string, ids []string) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	for _, id := range ids {
+		p.removeShadow(key(ns, id))
+	}
+
+	return nil
+}
+
+// Promote replaces the live queries with the given ids by their shadow
+// versions, removing the shadow queries.
+func (p *Percolator) Promote(ids []string) error {
+	return p.promote(DefaultNamespace, ids)
+}
+
+// promote replaces live queries in a namespace by their shadow versions.
+func (p *Percolator) promote(ns string, ids []string) error {
+	if err := p.begin(); err != nil {
+		return err
+	}
+	defer p.end()
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	qrys := make([]Query, len(ids))
+	for i, id := range ids {
+		s, ok := p.shadows[key(ns, id)]
+		if !ok {
+			return ErrorQueryNotFound
+		}
+		qrys[i] = s.c.query
+	}
+
+	if err := p.updateLocked(ns, qrys); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		p.removeShadow(key(ns, id))
+	}
+
+	return nil
+}
+
+// removeShadow removes a shadow query. The cache lock must be held for writing.
+func (p *Percolator) removeShadow(k string) {
+	s, ok := p.shadows[k]
+	if !ok {
+		return
+	}
+
+	delete(p.shadows, k)
+	p.forget(s.key)
+}
+
+// ShadowReport returns the report of the shadow query with the given id.
+func (p *Percolator) ShadowReport(id string) (ShadowReport, bool) {
+	return p.shadowReport(DefaultNamespace, id)
+}
+
+// shadowReport returns the report of a shadow query in a namespace.
+func (p *Percolator) shadowReport(ns, id string) (ShadowReport, bool) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	s, ok := p.shadows[key(ns, id)]
+	if !ok {
+		return ShadowReport{}, false
+	}
+
+	return s.snapshot(), true
+}
+
+// ShadowReports returns the reports of all shadow queries in all namespaces,
+// ordered by namespace and id.
+func (p *Percolator) ShadowReports() []ShadowReport {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	reports := make([]ShadowReport, 0, len(p.shadows))
+	for _, s := range p.shadows {
+		reports = append(reports, s.snapshot())
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Namespace != reports[j].Namespace {
+			return reports[i].Namespace < reports[j].Namespace
+		}
+		return reports[i].Id < reports[j].Id
+	})
+
+	return reports
+}
@@ -0,0 +1,200 @@
+package isenzo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_Shadow(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Stage([]isenzo.Query{isenzo.NewQuery("1", "foo:baz")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	docs := []struct {
+		Doc  map[string]interface{}
+		Want int
+	}{
+		{map[string]interface{}{"foo": "bar"}, 1},
+		{map[string]interface{}{"foo": "baz"}, 0},
+		{map[string]interface{}{"foo": "qux"}, 0},
+	}
+	for i, d := range docs {
+		res, err := p.Match(d.Doc)
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if len(res.Ids) != d.Want {
+			t.Fatalf("%d: expected %d results; got %v", i, d.Want, res.Ids)
+		}
+	}
+
+	r, ok := p.ShadowReport("1")
+	if !ok {
+		t.Fatal("expected shadow report; got none")
+	}
+
+	if r.Documents != 3 || r.Matches != 1 || r.LiveMatches != 1 || r.NewMatches != 1 || r.LostMatches != 1 {
+		t.Fatalf("expected 3 documents, 1 match, 1 live match, 1 new and 1 lost match; got %+v", r)
+	}
+
+	if len(r.Disagreements) != 2 || r.Disagreements[0].Type != isenzo.LostMatch || r.Disagreements[1].Type != isenzo.NewMatch {
+		t.Fatalf("expected lost and new disagreements; got %+v", r.Disagreements)
+	}
+
+	if len(r.Buckets) != 1 || r.Buckets[0].Documents != 3 {
+		t.Fatalf("expected 1 bucket of 3 documents; got %+v", r.Buckets)
+	}
+}
+
+func TestPercolator_ShadowNewQuery(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Stage([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	res, err := p.Match(map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if len(res.Ids) != 0 || res.QueriesRun != 0 {
+		t.Fatalf("expected no results or queries run; got %v and %d", res.Ids, res.QueriesRun)
+	}
+
+	if r, _ := p.ShadowReport("1"); r.NewMatches != 1 {
+		t.Fatalf("expected %d new matches; got %d", 1, r.NewMatches)
+	}
+}
+
+func TestPercolator_ShadowInactiveLive(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	p, err := isenzo.NewPercolator(isenzo.WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	live := isenzo.NewQuery("1", "foo:bar")
+	live.ActiveFrom = now.Add(time.Hour)
+	if err := p.Update([]isenzo.Query{live}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Stage([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	r, _ := p.ShadowReport("1")
+	if r.Documents != 1 || r.Matches != 1 || r.Uncompared != 1 || r.NewMatches != 0 || len(r.Disagreements) != 0 {
+		t.Fatalf("expected 1 uncompared document and no disagreements; got %+v", r)
+	}
+}
+
+func TestPercolator_ShadowBuckets(t *testing.T) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	p, err := isenzo.NewPercolator(
+		isenzo.WithClock(func() time.Time { return now }),
+		isenzo.WithShadowReport(1, time.Minute, 2),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Stage([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	r, _ := p.ShadowReport("1")
+	if len(r.Buckets) != 2 || !r.Buckets[0].Start.Equal(now.Add(-2*time.Minute)) {
+		t.Fatalf("expected the 2 most recent buckets; got %+v", r.Buckets)
+	}
+
+	if len(r.Disagreements) != 1 || !r.Disagreements[0].Time.Equal(now.Add(-time.Minute)) {
+		t.Fatalf("expected the most recent disagreement; got %+v", r.Disagreements)
+	}
+}
+
+func TestPercolator_ShadowNamespaces(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	a, _ := p.Namespace("a")
+	b, _ := p.Namespace("b")
+	if err := a.Stage([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := b.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if r, _ := a.ShadowReport("1"); r.Documents != 0 {
+		t.Fatalf("expected %d documents; got %d", 0, r.Documents)
+	}
+
+	if _, ok := p.ShadowReport("1"); ok {
+		t.Fatal("expected no shadow report in the default namespace")
+	}
+}
+
+func TestPercolator_Promote(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")})
+	p.Stage([]isenzo.Query{isenzo.NewQuery("1", "foo:baz"), isenzo.NewQuery("2", "foo:baz")})
+
+	if err := p.Promote([]string{"1"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if q, _ := p.Query("1"); q.Query != "foo:baz" {
+		t.Fatalf("expected query foo:baz; got %s", q.Query)
+	}
+
+	if _, ok := p.ShadowReport("1"); ok {
+		t.Fatal("expected promoted shadow query to be removed")
+	}
+
+	if err := p.Promote([]string{"3"}); err != isenzo.ErrorQueryNotFound {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorQueryNotFound, err)
+	}
+
+	if err := p.Unstage([]string{"2"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if reports := p.ShadowReports(); len(reports) != 0 {
+		t.Fatalf("expected no shadow reports; got %v", reports)
+	}
+}
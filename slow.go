@@ -0,0 +1,83 @@
+package isenzo
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/document"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+// maxSlowQueries is the number of slow queries kept by the Percolator.
+const maxSlowQueries = 100
+
+// SlowQuery represents a query evaluation that exceeded the slow query threshold.
+type SlowQuery struct {
+	Namespace   string        `json:"namespace,omitempty"`
+	Id          string        `json:"id"`
+	Took        time.Duration `json:"took"`
+	Fingerprint string        `json:"fingerprint"`
+	Time        time.Time     `json:"time"`
+	Err         error         `json:"-"`
+}
+
+// recordSlow records a slow query, evicting the oldest once full.
+func (p *Percolator) recordSlow(doc interface{}, c *cachedQuery, took time.Duration, err error) {
+	sq := SlowQuery{
+		Namespace:   c.namespace,
+		Id:          c.query.Id,
+		Took:        took,
+		Fingerprint: fingerprint(doc),
+		Time:        time.Now(),
+		Err:         err,
+	}
+
+	if p.slowLogger != nil {
+		p.slowLogger.Printf("isenzo: slow query %s took %s on document %s", sq.Id, sq.Took, sq.Fingerprint)
+	}
+
+	p.slowLock.Lock()
+	defer p.slowLock.Unlock()
+
+	if len(p.slow) >= maxSlowQueries {
+		copy(p.slow, p.slow[1:])
+		p.slow = p.slow[:len(p.slow)-1]
+	}
+	p.slow = append(p.slow, sq)
+}
+
+// SlowQueries returns the most recent slow queries, oldest first.
+func (p *Percolator) SlowQueries() []SlowQuery {
+	p.slowLock.Lock()
+	defer p.slowLock.Unlock()
+
+	slow := make([]SlowQuery, len(p.slow))
+	copy(slow, p.slow)
+
+	return slow
+}
+
+// fingerprint returns a stable hash of a mapped document, independent of field order.
+func fingerprint(doc interface{}) string {
+	var d *document.Document
+	switch t := doc.(type) {
+	case *document.Document:
+		d = t
+	case *matchers.NestedDocument:
+		d = t.Doc
+	default:
+		return ""
+	}
+
+	var sum uint64
+	for _, f := range d.Fields {
+		h := fnv.New64a()
+		h.Write([]byte(f.Name()))
+		h.Write([]byte{0})
+		h.Write(f.Value())
+		sum += h.Sum64()
+	}
+
+	return strconv.FormatUint(sum, 16)
+}
@@ -0,0 +1,45 @@
+package isenzo_test
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_SlowQueries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	p, err := isenzo.NewPercolator(
+		isenzo.WithSlowQueryLog(time.Nanosecond, log.New(buf, "", 0)),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	slow := p.SlowQueries()
+	if len(slow) != 1 {
+		t.Fatalf("expected %d slow queries; got %d", 1, len(slow))
+	}
+
+	if slow[0].Id != "1" || slow[0].Fingerprint == "" {
+		t.Fatalf("expected slow query 1 with fingerprint; got %+v", slow[0])
+	}
+
+	if !strings.Contains(buf.String(), "slow query 1") {
+		t.Fatalf("expected slow query to be logged; got %q", buf.String())
+	}
+}
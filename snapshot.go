@@ -0,0 +1,415 @@
+package isenzo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/document"
+	"github.com/nrwiersma/isenzo/presearchers"
+)
+
+// snapshotVersion is the version of the snapshot format written by Export.
+const snapshotVersion = 1
+
+// snapshotMagic prefixes snapshots in the binary format.
+var snapshotMagic = []byte("ISNZ")
+
+var (
+	ErrorSnapshotVersion   = errors.New("unsupported snapshot version")
+	ErrorSnapshotTruncated = errors.New("snapshot is truncated")
+)
+
+type exportOptions struct {
+	binary    bool
+	presearch bool
+}
+
+type exportFunc func(*exportOptions)
+
+// ExportBinary writes the snapshot in the compact binary format instead of JSON lines.
+func ExportBinary() exportFunc {
+	return func(o *exportOptions) {
+		o.binary = true
+	}
+}
+
+// ExportPresearchIndex includes the presearch documents of the queries in
+// the snapshot, so importing does not need to run the presearcher.
+func ExportPresearchIndex() exportFunc {
+	return func(o *exportOptions) {
+		o.presearch = true
+	}
+}
+
+// snapshotHeader is the first record of a snapshot.
+type snapshotHeader struct {
+	Version     int    `json:"version"`
+	Revision    uint64 `json:"revision,omitempty"`
+	Count       int    `json:"count"`
+	Presearch   bool   `json:"presearch,omitempty"`
+	Presearcher string `json:"presearcher,omitempty"`
+}
+
+// snapshotRecord is a query in a snapshot.
+type snapshotRecord struct {
+	Namespace string          `json:"namespace,omitempty"`
+	Query     Query           `json:"query"`
+	Presearch []snapshotField `json:"presearch,omitempty"`
+}
+
+// snapshotField is a field of a presearch document.
+type snapshotField struct {
+	Kind           string   `json:"kind"`
+	Name           string   `json:"name"`
+	ArrayPositions []uint64 `json:"array_positions,omitempty"`
+	Value          []byte   `json:"value"`
+	Options        int      `json:"options,omitempty"`
+}
+
+// newSnapshotFields converts the fields of a presearch document, leaving out
+// the namespace field.
+func newSnapshotFields(doc *document.Document) []snapshotField {
+	fields := make([]snapshotField, 0, len(doc.Fields))
+	for _, f := range doc.Fields {
+		if f.Name() == namespaceField {
+			continue
+		}
+
+		var kind string
+		switch f.(type) {
+		case *document.TextField:
+			kind = "text"
+		case *document.NumericField:
+			kind = "numeric"
+		case *document.DateTimeField:
+			kind = "datetime"
+		case *document.BooleanField:
+			kind = "boolean"
+		default:
+			continue
+		}
+
+		fields = append(fields, snapshotField{
+			Kind:           kind,
+			Name:           f.Name(),
+			ArrayPositions: f.ArrayPositions(),
+			Value:          f.Value(),
+			Options:        int(f.Options()),
+		})
+	}
+
+	return fields
+}
+
+// newPresearchDocument creates a presearch document from snapshot fields.
+func newPresearchDocument(id string, fields []snapshotField) (*document.Document, error) {
+	doc := document.NewDocument(id)
+	for _, f := range fields {
+		switch f.Kind {
+		case "text":
+			doc.AddField(document.NewTextFieldWithIndexingOptions(f.Name, f.ArrayPositions, f.Value, document.IndexingOptions(f.Options)))
+		case "numeric":
+			doc.AddField(document.NewNumericFieldFromBytes(f.Name, f.ArrayPositions, f.Value))
+		case "datetime":
+			doc.AddField(document.NewDateTimeFieldFromBytes(f.Name, f.ArrayPositions, f.Value))
+		case "boolean":
+			doc.AddField(document.NewBooleanFieldFromBytes(f.Name, f.ArrayPositions, f.Value))
+		default:
+			return nil, fmt.Errorf("unknown presearch field kind %q", f.Kind)
+		}
+	}
+
+	return doc, nil
+}
+
+// presearcherFingerprint returns the identity of a presearcher recorded with
+// the presearch documents of a snapshot: its type and a hash of its
+// configuration, including its mapping. A presearcher whose configuration
+// cannot be encoded has no fingerprint, so its documents are never reused.
+func presearcherFingerprint(presearcher presearchers.Presearcher) string {
+	b, err := json.Marshal(presearcher)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%T:%x", presearcher, sha256.Sum256(b))
+}
+
+// snapshotEncoder writes snapshot records.
+type snapshotEncoder interface {
+	Encode(v interface{}) error
+}
+
+// Export writes all queries of all namespaces to the writer, ordered by
+// namespace and id.
+//
+// By default the snapshot is written as JSON lines, a header line followed
+// by a line per query.
+func (p *Percolator) Export(w io.Writer, opts ...exportFunc) error {
+	o := &exportOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	hdr, recs := p.snapshot(o.presearch)
+
+	var enc snapshotEncoder
+	if o.binary {
+		if _, err := w.Write(snapshotMagic); err != nil {
+			return err
+		}
+		enc = gob.NewEncoder(w)
+	} else {
+		enc = json.NewEncoder(w)
+	}
+
+	if err := enc.Encode(hdr); err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshot returns the header and records of a snapshot of all queries,
+// ordered by namespace and id, so they can be written without holding the
+// cache lock.
+func (p *Percolator) snapshot(presearch bool) (snapshotHeader, []snapshotRecord) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	keys := make([]string, 0, len(p.cache))
+	for k := range p.cache {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hdr := snapshotHeader{
+		Version:   snapshotVersion,
+		Revision:  p.Revision(),
+		Count:     len(keys),
+		Presearch: presearch && p.presearcher != nil,
+	}
+	if hdr.Presearch {
+		hdr.Presearcher = presearcherFingerprint(p.presearcher)
+	}
+
+	recs := make([]snapshotRecord, len(keys))
+	for i, k := range keys {
+		c := p.cache[k]
+
+		recs[i] = snapshotRecord{Namespace: c.namespace, Query: c.query}
+		if hdr.Presearch && c.presearch != nil {
+			recs[i].Presearch = newSnapshotFields(c.presearch)
+		}
+	}
+
+	return hdr, recs
+}
+
+// Import replaces all queries of all namespaces with the queries in the
+// snapshot read from the reader, in either format written by Export.
+//
+// The snapshot is read and indexed before the queries are replaced, so
+// concurrent matches see either the old or the new queries. If the snapshot
+// is invalid, the queries are left unchanged.
+func (p *Percolator) Import(r io.Reader) error {
+	_, err := p.Restore(r)
+	return err
+}
+
+// Restore imports a snapshot like Import, returning the change revision of
+// the Percolator the snapshot was exported from.
+func (p *Percolator) Restore(r io.Reader) (uint64, error) {
+	if err := p.begin(); err != nil {
+		return 0, err
+	}
+	defer p.end()
+
+	hdr, recs, err := readSnapshot(r)
+	if err != nil {
+		return 0, err
+	}
+
+	queryIndex, err := presearchers.NewIndex(bleve.NewIndexMapping())
+	if err != nil {
+		return 0, err
+	}
+
+	// Presearch documents are only reused when written by a presearcher
+	// with the same configuration and mapping.
+	reuse := false
+	if hdr.Presearch && p.presearcher != nil {
+		fp := presearcherFingerprint(p.presearcher)
+		reuse = fp != "" && hdr.Presearcher == fp
+	}
+
+	cache := make(map[string]*cachedQuery, len(recs))
+	counts := map[string]int{}
+	for _, rec := range recs {
+		if err := p.importRecord(queryIndex, cache, rec, reuse); err != nil {
+			queryIndex.Close()
+			return 0, err
+		}
+		counts[rec.Namespace]++
+	}
+
+	p.cacheLock.Lock()
+	for ns, n := range counts {
+		if limit := p.limit(p.namespace(ns)); limit > 0 && n > limit {
+			p.cacheLock.Unlock()
+			queryIndex.Close()
+			return 0, ErrorNamespaceLimit
+		}
+	}
+
+	p.recordImport(cache)
+
+	for k := range p.cache {
+		if _, ok := cache[k]; !ok {
+			p.forget(k)
+		}
+	}
+
+	old := p.queryIndex
+	p.cache = cache
+	p.queryIndex = queryIndex
+	for ns, state := range p.namespaces {
+		state.queries = counts[ns]
+	}
+	p.cacheLock.Unlock()
+
+	return hdr.Revision, old.Close()
+}
+
+// recordImport records the changes from the current queries to the imported
+// queries, ordered by key. The cache lock must be held for writing.
+func (p *Percolator) recordImport(cache map[string]*cachedQuery) {
+	keys := make([]string, 0, len(p.cache)+len(cache))
+	for k := range p.cache {
+		if _, ok := cache[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range cache {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		old, ok := p.cache[k]
+		c, imported := cache[k]
+		switch {
+		case !imported:
+			p.recordChange(ChangeDeleted, old.namespace, old.query)
+		case !ok:
+			p.recordChange(ChangeAdded, c.namespace, c.query)
+		case !reflect.DeepEqual(old.query, c.query):
+			p.recordChange(ChangeUpdated, c.namespace, c.query)
+		}
+	}
+}
+
+// importRecord adds a snapshot record to a cache and query index being
+// imported, reusing the presearch document of the record if requested.
+func (p *Percolator) importRecord(i *presearchers.Index, cache map[string]*cachedQuery, rec snapshotRecord, reuse bool) error {
+	if rec.Query.Id == "" {
+		return errors.New("query id cannot be empty")
+	}
+
+	if strings.Contains(rec.Namespace, "/") {
+		return ErrorInvalidNamespace
+	}
+
+	c, err := p.newCachedQuery(rec.Namespace, rec.Query)
+	if err != nil {
+		return err
+	}
+
+	k := key(rec.Namespace, rec.Query.Id)
+	if _, ok := cache[k]; ok {
+		return fmt.Errorf("duplicate query %s in snapshot", k)
+	}
+
+	if p.presearcher != nil {
+		// Presearch documents of another presearcher cannot be used, so the
+		// queries are indexed with the presearcher of the Percolator instead.
+		if reuse {
+			c.presearch, err = newPresearchDocument(k, rec.Presearch)
+			if err != nil {
+				return err
+			}
+		} else {
+			c.presearch = p.presearcher.IndexQuery(k, c.qry)
+		}
+
+		if err := indexPresearch(i, rec.Namespace, c.presearch); err != nil {
+			return err
+		}
+	}
+
+	cache[k] = c
+	return nil
+}
+
+// snapshotDecoder reads snapshot records.
+type snapshotDecoder interface {
+	Decode(v interface{}) error
+}
+
+// readSnapshot reads a snapshot in either format.
+func readSnapshot(r io.Reader) (snapshotHeader, []snapshotRecord, error) {
+	br := bufio.NewReader(r)
+
+	var dec snapshotDecoder
+	if magic, err := br.Peek(len(snapshotMagic)); err == nil && bytes.Equal(magic, snapshotMagic) {
+		br.Discard(len(snapshotMagic))
+		dec = gob.NewDecoder(br)
+	} else {
+		dec = json.NewDecoder(br)
+	}
+
+	var hdr snapshotHeader
+	if err := dec.Decode(&hdr); err != nil {
+		return hdr, nil, fmt.Errorf("could not read snapshot header: %v", err)
+	}
+
+	if hdr.Version != snapshotVersion {
+		return hdr, nil, ErrorSnapshotVersion
+	}
+
+	recs := make([]snapshotRecord, 0)
+	for {
+		var rec snapshotRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return hdr, nil, fmt.Errorf("could not read snapshot record: %v", err)
+		}
+
+		recs = append(recs, rec)
+	}
+
+	if len(recs) != hdr.Count {
+		return hdr, nil, ErrorSnapshotTruncated
+	}
+
+	return hdr, recs, nil
+}
@@ -0,0 +1,287 @@
+package isenzo_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/matchers"
+	"github.com/nrwiersma/isenzo/presearchers"
+)
+
+func TestPercolator_ExportImport(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		src := newSnapshotPercolator(t)
+
+		var buf bytes.Buffer
+		var err error
+		if binary {
+			err = src.Export(&buf, isenzo.ExportBinary(), isenzo.ExportPresearchIndex())
+		} else {
+			err = src.Export(&buf)
+		}
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		dst, err := isenzo.NewPercolator()
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if err := dst.Update([]isenzo.Query{isenzo.NewQuery("old", "foo:bar")}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if err := dst.Import(&buf); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		qrys := dst.Queries()
+		if len(qrys) != 2 || qrys[0].Id != "1" || qrys[1].Id != "2" {
+			t.Fatalf("expected queries [1 2]; got %v", qrys)
+		}
+
+		if !qrys[1].ExpiresAt.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Fatalf("expected expiry to be imported; got %v", qrys[1].ExpiresAt)
+		}
+
+		ns, err := dst.Namespace("tenant")
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if qrys := ns.Queries(); len(qrys) != 1 || qrys[0].Id != "3" {
+			t.Fatalf("expected namespace queries [3]; got %v", qrys)
+		}
+
+		res, err := dst.Match(map[string]interface{}{"foo": "bar"})
+		if err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if len(res.Ids) != 1 || res.Ids[0] != "1" {
+			t.Fatalf("expected results [1]; got %v", res.Ids)
+		}
+	}
+}
+
+func TestPercolator_ExportImportPresearchIndex(t *testing.T) {
+	src := newSnapshotPercolator(t)
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf, isenzo.ExportPresearchIndex()); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	snapshot := buf.String()
+
+	lines := strings.Split(strings.TrimSpace(snapshot), "\n")
+	var hdr map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &hdr); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	name, _ := hdr["presearcher"].(string)
+	if hdr["presearch"] != true || !strings.HasPrefix(name, "*presearchers.TermPresearcher:") {
+		t.Fatalf("expected header with the presearcher; got %v", hdr)
+	}
+	for _, line := range lines[1:] {
+		var rec struct {
+			Presearch []map[string]interface{} `json:"presearch"`
+		}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+
+		if len(rec.Presearch) == 0 {
+			t.Fatalf("expected presearch fields; got %q", line)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		presearcher presearchers.Presearcher
+		run         int
+	}{
+		{
+			name:        "SamePresearcher",
+			presearcher: &presearchers.TermPresearcher{},
+			run:         1,
+		},
+		{
+			name:        "OtherPresearcher",
+			presearcher: &otherPresearcher{},
+			run:         2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst, err := isenzo.NewPercolator(isenzo.WithPresearcher(tt.presearcher))
+			if err != nil {
+				t.Fatalf("unexpected err; got %v", err)
+			}
+
+			if err := dst.Import(strings.NewReader(snapshot)); err != nil {
+				t.Fatalf("unexpected err; got %v", err)
+			}
+
+			res, err := dst.Match(map[string]interface{}{"foo": "bar"})
+			if err != nil {
+				t.Fatalf("unexpected err; got %v", err)
+			}
+
+			if len(res.Ids) != 1 || res.Ids[0] != "1" {
+				t.Fatalf("expected results [1]; got %v", res.Ids)
+			}
+			if res.QueriesRun != tt.run {
+				t.Fatalf("expected %d queries run; got %d", tt.run, res.QueriesRun)
+			}
+		})
+	}
+}
+
+func TestPercolator_ImportPresearchIndexOtherMapping(t *testing.T) {
+	src, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	if err := src.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:Bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf, isenzo.ExportPresearchIndex()); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	// The keyword analyzer keeps the case of the terms, so the presearch
+	// terms of the snapshot would miss the document.
+	m := bleve.NewIndexMapping()
+	m.DefaultAnalyzer = keyword.Name
+	dst, err := isenzo.NewPercolator(isenzo.WithMatcherFactory(matchers.NewIndexMatcherFactory(m)))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := dst.Import(&buf); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	res, err := dst.Match(map[string]interface{}{"foo": "Bar"})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if len(res.Ids) != 1 || res.Ids[0] != "1" {
+		t.Fatalf("expected results [1]; got %v", res.Ids)
+	}
+}
+
+func TestPercolator_ExportFormat(t *testing.T) {
+	p := newSnapshotPercolator(t)
+
+	var buf bytes.Buffer
+	if err := p.Export(&buf); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	lines := 0
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var v map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &v); err != nil {
+			t.Fatalf("expected json line; got %q", s.Text())
+		}
+
+		if lines == 0 && v["version"] != float64(1) {
+			t.Fatalf("expected header with version 1; got %v", v)
+		}
+		lines++
+	}
+
+	if lines != 4 {
+		t.Fatalf("expected %d lines; got %d", 4, lines)
+	}
+}
+
+func TestPercolator_ImportInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+	}{
+		{
+			name:     "Version",
+			snapshot: `{"version":2,"count":0}`,
+		},
+		{
+			name:     "Truncated",
+			snapshot: "{\"version\":1,\"count\":2}\n{\"query\":{\"id\":\"1\",\"query\":\"foo:bar\"}}\n",
+		},
+		{
+			name:     "InvalidQuery",
+			snapshot: "{\"version\":1,\"count\":1}\n{\"query\":{\"id\":\"1\",\"query\":\"foo:(\"}}\n",
+		},
+		{
+			name:     "Duplicate",
+			snapshot: "{\"version\":1,\"count\":2}\n{\"query\":{\"id\":\"1\",\"query\":\"foo:bar\"}}\n{\"query\":{\"id\":\"1\",\"query\":\"foo:bar\"}}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newSnapshotPercolator(t)
+
+			if err := p.Import(strings.NewReader(tt.snapshot)); err == nil {
+				t.Fatal("expected error; got nil")
+			}
+
+			if qrys := p.Queries(); len(qrys) != 2 {
+				t.Fatalf("expected queries to be unchanged; got %v", qrys)
+			}
+		})
+	}
+}
+
+func newSnapshotPercolator(t *testing.T) *isenzo.Percolator {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	expiring := isenzo.NewQuery("2", "baz:bat")
+	expiring.ExpiresAt = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), expiring}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	ns, err := p.Namespace("tenant")
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := ns.Update([]isenzo.Query{isenzo.NewQuery("3", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	return p
+}
+
+// otherPresearcher selects every query as a candidate.
+type otherPresearcher struct {
+	presearchers.TermPresearcher
+}
+
+func (p *otherPresearcher) IndexQuery(id string, q query.Query) *document.Document {
+	doc := document.NewDocument(id)
+	doc.AddField(document.NewTextFieldWithIndexingOptions(presearchers.AnyField, nil, []byte("any"), document.IndexField))
+
+	return doc
+}
@@ -0,0 +1,138 @@
+package isenzo
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// QueryStats represents the statistics of a query.
+type QueryStats struct {
+	Namespace   string        `json:"namespace,omitempty"`
+	Id          string        `json:"id"`
+	Candidates  int64         `json:"candidates"`
+	Matches     int64         `json:"matches"`
+	Errors      int64         `json:"errors"`
+	LastMatched time.Time     `json:"last_matched"`
+	EvalTime    time.Duration `json:"eval_time"`
+	Loaded      time.Time     `json:"loaded"`
+}
+
+// queryStats holds the counters of a query. All fields are updated atomically.
+type queryStats struct {
+	candidates  int64
+	matches     int64
+	errors      int64
+	lastMatched int64
+	evalTime    int64
+
+	loaded time.Time
+}
+
+func newQueryStats(now time.Time) *queryStats {
+	return &queryStats{loaded: now}
+}
+
+func (s *queryStats) observe(now time.Time, took time.Duration, matched bool, err error) {
+	atomic.AddInt64(&s.evalTime, int64(took))
+
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+		return
+	}
+
+	if matched {
+		atomic.AddInt64(&s.matches, 1)
+		atomic.StoreInt64(&s.lastMatched, now.UnixNano())
+	}
+}
+
+func (s *queryStats) snapshot(ns, id string) QueryStats {
+	qs := QueryStats{
+		Namespace:  ns,
+		Id:         id,
+		Candidates: atomic.LoadInt64(&s.candidates),
+		Matches:    atomic.LoadInt64(&s.matches),
+		Errors:     atomic.LoadInt64(&s.errors),
+		EvalTime:   time.Duration(atomic.LoadInt64(&s.evalTime)),
+		Loaded:     s.loaded,
+	}
+
+	if last := atomic.LoadInt64(&s.lastMatched); last != 0 {
+		qs.LastMatched = time.Unix(0, last)
+	}
+
+	return qs
+}
+
+// observe records a query evaluation reported by the matchers.
+//
+// It is called during Match while the cache read lock is held.
+func (p *Percolator) observe(doc interface{}, k string, took time.Duration, matched bool, err error) {
+	c, ok := p.cache[k]
+	if !ok {
+		return
+	}
+
+	c.stats.observe(p.now(), took, matched, err)
+
+	if err != nil {
+		p.checkQuarantine(c, err)
+	}
+
+	if p.slowThreshold > 0 && took >= p.slowThreshold {
+		p.recordSlow(doc, c, took, err)
+	}
+}
+
+// Stats returns the statistics of the query with the given id.
+func (p *Percolator) Stats(id string) (QueryStats, bool) {
+	return p.stats(DefaultNamespace, id)
+}
+
+// stats returns the statistics of the query with the given id in a namespace.
+func (p *Percolator) stats(ns, id string) (QueryStats, bool) {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	c, ok := p.cache[key(ns, id)]
+	if !ok {
+		return QueryStats{}, false
+	}
+
+	return c.stats.snapshot(ns, id), true
+}
+
+// AllStats returns the statistics of all queries in all namespaces, ordered
+// by namespace and id.
+func (p *Percolator) AllStats() []QueryStats {
+	p.cacheLock.RLock()
+	defer p.cacheLock.RUnlock()
+
+	stats := make([]QueryStats, 0, len(p.cache))
+	for _, c := range p.cache {
+		stats = append(stats, c.stats.snapshot(c.namespace, c.query.Id))
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Namespace != stats[j].Namespace {
+			return stats[i].Namespace < stats[j].Namespace
+		}
+		return stats[i].Id < stats[j].Id
+	})
+
+	return stats
+}
+
+// NeverMatched returns the statistics of all queries that have not matched
+// since they were loaded, ordered by namespace and id.
+func (p *Percolator) NeverMatched() []QueryStats {
+	var stats []QueryStats
+	for _, s := range p.AllStats() {
+		if s.Matches == 0 {
+			stats = append(stats, s)
+		}
+	}
+
+	return stats
+}
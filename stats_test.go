@@ -0,0 +1,92 @@
+package isenzo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/nrwiersma/isenzo"
+	"github.com/nrwiersma/isenzo/matchers"
+)
+
+func TestPercolator_Stats(t *testing.T) {
+	p, err := isenzo.NewPercolator(
+		isenzo.WithMatcherFactory(matchers.NewParallelMatcherFactory(matchers.NewIndexMatcherFactory(bleve.NewIndexMapping()), 2)),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	err = p.Update([]isenzo.Query{
+		isenzo.NewQuery("1", "foo:bar"),
+		isenzo.NewQuery("2", "test"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+
+	stats, ok := p.Stats("1")
+	if !ok {
+		t.Fatal("expected stats; got none")
+	}
+
+	if stats.Candidates != 3 || stats.Matches != 3 {
+		t.Fatalf("expected 3 candidates and 3 matches; got %d and %d", stats.Candidates, stats.Matches)
+	}
+
+	if stats.LastMatched.IsZero() {
+		t.Fatal("expected last matched time; got none")
+	}
+
+	if _, ok := p.Stats("3"); ok {
+		t.Fatal("expected no stats; got some")
+	}
+
+	if all := p.AllStats(); len(all) != 2 {
+		t.Fatalf("expected %d stats; got %d", 2, len(all))
+	}
+
+	never := p.NeverMatched()
+	if len(never) != 1 || never[0].Id != "2" {
+		t.Fatalf("expected never matched [2]; got %v", never)
+	}
+}
+
+func TestPercolator_StatsClock(t *testing.T) {
+	now := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+	p, err := isenzo.NewPercolator(isenzo.WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar")}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := p.Match(map[string]interface{}{"foo": "bar"}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	stats, _ := p.Stats("1")
+	if !stats.Loaded.Equal(now.Add(-time.Hour)) || !stats.LastMatched.Equal(now) {
+		t.Fatalf("expected loaded %v and last matched %v; got %v and %v", now.Add(-time.Hour), now, stats.Loaded, stats.LastMatched)
+	}
+}
+
+func TestPercolator_SharedMatcherFactory(t *testing.T) {
+	f := matchers.NewIndexMatcherFactory(bleve.NewIndexMapping())
+	if _, err := isenzo.NewPercolator(isenzo.WithMatcherFactory(f)); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if _, err := isenzo.NewPercolator(isenzo.WithMatcherFactory(f)); err != matchers.ErrorObserverSet {
+		t.Fatalf("expected %v; got %v", matchers.ErrorObserverSet, err)
+	}
+}
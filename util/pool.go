@@ -1,38 +1,311 @@
 package util
 
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minEvictInterval is the minimum interval at which idle items are evicted
+// in the background.
+const minEvictInterval = time.Millisecond
+
+// PoolStats represents the statistics of a pool.
+type PoolStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Live      int
+	Idle      int
+}
+
+type idleItem struct {
+	item  interface{}
+	since time.Time
+}
+
 // Pool represents an object pool.
 type Pool struct {
-	pool chan interface{}
+	hits      int64
+	misses    int64
+	evictions int64
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	idle   []idleItem
+	max    int
+	live   int
+	closed bool
+	now    func() time.Time
+	stop   chan struct{}
 
+	// New creates a new item when the pool is empty.
 	New func() interface{}
+	// Destroy releases an item discarded or evicted from the pool.
+	Destroy func(interface{})
+	// MaxLive is the maximum number of items created and not yet destroyed,
+	// or 0 for no limit.
+	MaxLive int
+	// Wait determines if Get blocks when MaxLive is reached, rather than
+	// returning nil.
+	Wait bool
+	// IdleTimeout is the time after which an idle item is evicted, or 0 to
+	// keep idle items. Idle items are also evicted in the background, from
+	// the first use of the pool until it is closed.
+	IdleTimeout time.Duration
 }
 
 // NewPool create a new Pool instance.
 func NewPool(max int) *Pool {
-	return &Pool{
-		pool: make(chan interface{}, max),
+	p := &Pool{
+		idle: make([]idleItem, 0, max),
+		max:  max,
+		now:  time.Now,
 	}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
 }
 
 // Get retrieves an item from the pool, otherwise it creates a new item.
+//
+// When MaxLive is reached, Get waits for an item to be returned if Wait is
+// set, otherwise it returns nil. Get returns nil once the pool is closed.
 func (p *Pool) Get() interface{} {
-	var item interface{}
+	p.mu.Lock()
+	p.startEvictorLocked()
+	evicted := p.evictLocked()
 
-	select {
-	case item = <-p.pool:
-	default:
-		if p.New != nil {
-			item = p.New()
-		}
+	for !p.closed && len(p.idle) == 0 && p.MaxLive > 0 && p.live >= p.MaxLive && p.Wait {
+		p.cond.Wait()
+		evicted = append(evicted, p.evictLocked()...)
+	}
+
+	if p.closed {
+		p.mu.Unlock()
+		p.destroy(evicted)
+		return nil
+	}
+
+	if n := len(p.idle); n > 0 {
+		item := p.idle[n-1].item
+		p.idle[n-1] = idleItem{}
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+
+		p.destroy(evicted)
+		atomic.AddInt64(&p.hits, 1)
+		return item
+	}
+
+	atomic.AddInt64(&p.misses, 1)
+	if p.New == nil || (p.MaxLive > 0 && p.live >= p.MaxLive) {
+		p.mu.Unlock()
+		p.destroy(evicted)
+		return nil
+	}
+	p.live++
+	p.mu.Unlock()
+
+	p.destroy(evicted)
+
+	item := p.New()
+	if item == nil {
+		p.release()
 	}
 	return item
 }
 
 // Put adds the item back into the pool.
 func (p *Pool) Put(item interface{}) {
-	select {
-	case p.pool <- item:
-	default:
+	p.mu.Lock()
+	p.startEvictorLocked()
+	evicted := p.evictLocked()
+
+	if p.closed || len(p.idle) >= p.max {
 		// let it go, let it go...
+		p.mu.Unlock()
+		p.Discard(item)
+		p.destroy(evicted)
+		return
+	}
+
+	p.idle = append(p.idle, idleItem{item: item, since: p.now()})
+	p.cond.Signal()
+	p.mu.Unlock()
+
+	p.destroy(evicted)
+}
+
+// Discard destroys an item retrieved from the pool that will not be put back.
+func (p *Pool) Discard(item interface{}) {
+	p.release()
+	p.destroy([]interface{}{item})
+}
+
+// Warmup fills the pool with up to n new items, returning the number of items created.
+func (p *Pool) Warmup(n int) int {
+	if p.New == nil {
+		return 0
+	}
+
+	created := 0
+	for ; created < n; created++ {
+		p.mu.Lock()
+		if p.closed || len(p.idle) >= p.max || (p.MaxLive > 0 && p.live >= p.MaxLive) {
+			p.mu.Unlock()
+			break
+		}
+		p.live++
+		p.mu.Unlock()
+
+		item := p.New()
+		if item == nil {
+			p.release()
+			break
+		}
+
+		p.mu.Lock()
+		p.startEvictorLocked()
+		p.idle = append(p.idle, idleItem{item: item, since: p.now()})
+		p.cond.Signal()
+		p.mu.Unlock()
+	}
+
+	return created
+}
+
+// Evict destroys all items idle for longer than the IdleTimeout, returning the number of items evicted.
+func (p *Pool) Evict() int {
+	p.mu.Lock()
+	evicted := p.evictLocked()
+	p.mu.Unlock()
+
+	p.destroy(evicted)
+	return len(evicted)
+}
+
+// Close destroys all idle items and stops the background eviction. Items
+// put back after the pool is closed are destroyed, and Get no longer returns
+// items.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	closed := make([]interface{}, len(p.idle))
+	for i, idle := range p.idle {
+		closed[i] = idle.item
+	}
+	p.idle = nil
+	if p.live -= len(closed); p.live < 0 {
+		p.live = 0
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	p.destroy(closed)
+}
+
+// Stats returns the statistics of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	live, idle := p.live, len(p.idle)
+	p.mu.Unlock()
+
+	return PoolStats{
+		Hits:      atomic.LoadInt64(&p.hits),
+		Misses:    atomic.LoadInt64(&p.misses),
+		Evictions: atomic.LoadInt64(&p.evictions),
+		Live:      live,
+		Idle:      idle,
+	}
+}
+
+// startEvictorLocked starts evicting idle items in the background, if an
+// IdleTimeout is set and the evictor is not running. The lock must be held.
+func (p *Pool) startEvictorLocked() {
+	if p.IdleTimeout <= 0 || p.stop != nil || p.closed {
+		return
+	}
+
+	p.stop = make(chan struct{})
+	go p.evictor(p.stop, p.IdleTimeout)
+}
+
+// evictor evicts expired idle items until stopped.
+func (p *Pool) evictor(stop chan struct{}, timeout time.Duration) {
+	// Items are evicted at most half the timeout after they expire.
+	interval := timeout / 2
+	if interval < minEvictInterval {
+		interval = minEvictInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.Evict()
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+// evictLocked removes the expired idle items, returning them to be destroyed.
+// The lock must be held.
+func (p *Pool) evictLocked() []interface{} {
+	if p.IdleTimeout <= 0 || len(p.idle) == 0 {
+		return nil
+	}
+
+	// Idle items are ordered from least to most recently used.
+	cutoff := p.now().Add(-p.IdleTimeout)
+	n := 0
+	for n < len(p.idle) && p.idle[n].since.Before(cutoff) {
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	evicted := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		evicted[i] = p.idle[i].item
+	}
+	p.idle = append(p.idle[:0], p.idle[n:]...)
+
+	if p.live -= n; p.live < 0 {
+		p.live = 0
+	}
+	atomic.AddInt64(&p.evictions, int64(n))
+	p.cond.Broadcast()
+
+	return evicted
+}
+
+// release marks a live item as destroyed.
+func (p *Pool) release() {
+	p.mu.Lock()
+	if p.live > 0 {
+		p.live--
+	}
+	p.cond.Signal()
+	p.mu.Unlock()
+}
+
+// destroy destroys the items with the Destroy hook.
+func (p *Pool) destroy(items []interface{}) {
+	if p.Destroy == nil {
+		return
+	}
+
+	for _, item := range items {
+		p.Destroy(item)
 	}
 }
@@ -1,6 +1,9 @@
 package util
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestNewPool(t *testing.T) {
 	p := NewPool(10)
@@ -9,8 +12,8 @@ func TestNewPool(t *testing.T) {
 		t.Fatal("expected pool, got nil")
 	}
 
-	if cap(p.pool) != 10 {
-		t.Fatalf("expected pool size 10; got %d", len(p.pool))
+	if p.max != 10 {
+		t.Fatalf("expected pool size 10; got %d", p.max)
 	}
 }
 
@@ -22,7 +25,7 @@ func TestPool_Get(t *testing.T) {
 		t.Fatalf("expected nil item; got %v", item)
 	}
 
-	p.pool <- 3
+	p.Put(3)
 
 	item = p.Get()
 	if item != 3 {
@@ -40,12 +43,64 @@ func TestPool_GetWithNew(t *testing.T) {
 	}
 }
 
+func TestPool_GetWithMaxLive(t *testing.T) {
+	p := NewPool(10)
+	p.New = func() interface{} { return "foo" }
+	p.MaxLive = 1
+
+	item := p.Get()
+	if item != "foo" {
+		t.Fatalf("expected foo; got %v", item)
+	}
+
+	if item := p.Get(); item != nil {
+		t.Fatalf("expected nil item; got %v", item)
+	}
+
+	p.Put(item)
+
+	if item := p.Get(); item != "foo" {
+		t.Fatalf("expected foo; got %v", item)
+	}
+}
+
+func TestPool_GetWithWait(t *testing.T) {
+	p := NewPool(10)
+	p.New = func() interface{} { return "foo" }
+	p.MaxLive = 1
+	p.Wait = true
+
+	item := p.Get()
+
+	got := make(chan interface{})
+	go func() {
+		got <- p.Get()
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("expected get to wait; got item")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	p.Put(item)
+
+	select {
+	case item := <-got:
+		if item != "foo" {
+			t.Fatalf("expected foo; got %v", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected item; got none")
+	}
+}
+
 func TestPool_Put(t *testing.T) {
 	p := NewPool(1)
 
 	p.Put(3)
 
-	if len(p.pool) != 1 {
+	if len(p.idle) != 1 {
 		t.Fatal("expected pool to contain item; got none")
 	}
 
@@ -55,3 +110,139 @@ func TestPool_Put(t *testing.T) {
 		t.Fatalf("expected full pool to discard; got %v", err)
 	}
 }
+
+func TestPool_PutDestroysOverflow(t *testing.T) {
+	var destroyed []interface{}
+	p := NewPool(1)
+	p.Destroy = func(item interface{}) { destroyed = append(destroyed, item) }
+
+	p.Put(1)
+	p.Put(2)
+
+	if len(destroyed) != 1 || destroyed[0] != 2 {
+		t.Fatalf("expected destroyed [2]; got %v", destroyed)
+	}
+}
+
+func TestPool_Evict(t *testing.T) {
+	now := time.Now()
+	var destroyed []interface{}
+	p := NewPool(10)
+	p.Destroy = func(item interface{}) { destroyed = append(destroyed, item) }
+	p.IdleTimeout = time.Minute
+	p.now = func() time.Time { return now }
+
+	p.Put(1)
+	now = now.Add(30 * time.Second)
+	p.Put(2)
+	now = now.Add(45 * time.Second)
+
+	if n := p.Evict(); n != 1 {
+		t.Fatalf("expected %d evicted; got %d", 1, n)
+	}
+
+	if len(destroyed) != 1 || destroyed[0] != 1 {
+		t.Fatalf("expected destroyed [1]; got %v", destroyed)
+	}
+
+	if item := p.Get(); item != 2 {
+		t.Fatalf("expected 2; got %v", item)
+	}
+
+	if stats := p.Stats(); stats.Evictions != 1 {
+		t.Fatalf("expected %d evictions; got %d", 1, stats.Evictions)
+	}
+}
+
+func TestPool_EvictInBackground(t *testing.T) {
+	destroyed := make(chan interface{}, 1)
+	p := NewPool(10)
+	p.Destroy = func(item interface{}) { destroyed <- item }
+	p.IdleTimeout = 10 * time.Millisecond
+	defer p.Close()
+
+	p.Put(1)
+
+	select {
+	case item := <-destroyed:
+		if item != 1 {
+			t.Fatalf("expected destroyed 1; got %v", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected idle item to be evicted")
+	}
+
+	if stats := p.Stats(); stats.Evictions != 1 || stats.Idle != 0 {
+		t.Fatalf("expected 1 eviction and no idle items; got %+v", stats)
+	}
+}
+
+func TestPool_EvictInBackgroundTinyTimeout(t *testing.T) {
+	destroyed := make(chan interface{}, 1)
+	p := NewPool(10)
+	p.Destroy = func(item interface{}) { destroyed <- item }
+	p.IdleTimeout = time.Nanosecond
+	defer p.Close()
+
+	p.Put(1)
+
+	select {
+	case <-destroyed:
+	case <-time.After(time.Second):
+		t.Fatal("expected idle item to be evicted")
+	}
+}
+
+func TestPool_Warmup(t *testing.T) {
+	p := NewPool(2)
+	p.New = func() interface{} { return "foo" }
+
+	if n := p.Warmup(5); n != 2 {
+		t.Fatalf("expected %d items created; got %d", 2, n)
+	}
+
+	if stats := p.Stats(); stats.Idle != 2 || stats.Live != 2 {
+		t.Fatalf("expected 2 idle and 2 live items; got %+v", stats)
+	}
+}
+
+func TestPool_Stats(t *testing.T) {
+	p := NewPool(10)
+
+	p.Get()
+	p.Put(3)
+	p.Get()
+
+	stats := p.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("expected 1 hit and 1 miss; got %d hits and %d misses", stats.Hits, stats.Misses)
+	}
+}
+
+func TestPool_Close(t *testing.T) {
+	p := NewPool(10)
+	p.New = func() interface{} { return "foo" }
+	destroyed := 0
+	p.Destroy = func(interface{}) { destroyed++ }
+
+	p.Warmup(2)
+	item := p.Get()
+	p.Close()
+
+	if destroyed != 1 {
+		t.Fatalf("expected %d destroyed; got %d", 1, destroyed)
+	}
+
+	p.Put(item)
+	if destroyed != 2 {
+		t.Fatalf("expected %d destroyed; got %d", 2, destroyed)
+	}
+
+	if item := p.Get(); item != nil {
+		t.Fatalf("expected nil item; got %v", item)
+	}
+
+	if stats := p.Stats(); stats.Live != 0 || stats.Idle != 0 {
+		t.Fatalf("expected no live or idle items; got %+v", stats)
+	}
+}
@@ -0,0 +1,226 @@
+package util
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrorWorkerPoolFull   = errors.New("worker pool is full")
+	ErrorWorkerPoolClosed = errors.New("worker pool is closed")
+)
+
+// WorkerPoolStats represents the statistics of a worker pool.
+type WorkerPoolStats struct {
+	Workers   int
+	Pending   int
+	Running   int
+	Submitted int64
+	Rejected  int64
+}
+
+// WorkerPool represents a long lived pool of workers shared between groups of tasks.
+//
+// Workers take a task from each group with pending tasks in turn, so
+// concurrent groups are served fairly.
+type WorkerPool struct {
+	submitted int64
+	rejected  int64
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	groups  []*WorkGroup
+	next    int
+	workers int
+	pending int
+	running int
+	closed  bool
+	wg      sync.WaitGroup
+
+	// MaxPending is the maximum number of tasks submitted and not yet started,
+	// or 0 for no limit.
+	MaxPending int
+	// Wait determines if Submit blocks when MaxPending is reached, rather than
+	// returning ErrorWorkerPoolFull.
+	Wait bool
+}
+
+// NewWorkerPool creates a new WorkerPool and starts its workers.
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &WorkerPool{
+		groups:  make([]*WorkGroup, 0),
+		workers: workers,
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Group creates a new group of tasks, running at most limit tasks at once,
+// or any number of tasks if limit is 0.
+func (p *WorkerPool) Group(limit int) *WorkGroup {
+	return &WorkGroup{
+		pool:  p,
+		limit: limit,
+		tasks: make([]func(), 0),
+	}
+}
+
+// Stats returns the statistics of the worker pool.
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return WorkerPoolStats{
+		Workers:   p.workers,
+		Pending:   p.pending,
+		Running:   p.running,
+		Submitted: atomic.LoadInt64(&p.submitted),
+		Rejected:  atomic.LoadInt64(&p.rejected),
+	}
+}
+
+// Close stops the workers once all submitted tasks have run.
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+
+	p.wg.Wait()
+}
+
+// work runs tasks until the pool is closed.
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+
+	p.mu.Lock()
+	for {
+		g := p.nextLocked()
+		if g == nil {
+			if p.closed && p.pending == 0 {
+				p.mu.Unlock()
+				return
+			}
+
+			p.cond.Wait()
+			continue
+		}
+
+		fn := g.tasks[0]
+		g.tasks[0] = nil
+		g.tasks = g.tasks[1:]
+		g.running++
+		p.pending--
+		p.running++
+		p.mu.Unlock()
+		p.cond.Broadcast()
+
+		fn()
+
+		p.mu.Lock()
+		g.running--
+		p.running--
+		g.wg.Done()
+		p.cond.Broadcast()
+	}
+}
+
+// nextLocked returns the next group with a runnable task, removing it from
+// the queued groups if it is the last task of the group.
+func (p *WorkerPool) nextLocked() *WorkGroup {
+	n := len(p.groups)
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		g := p.groups[idx]
+		if g.limit > 0 && g.running >= g.limit {
+			continue
+		}
+
+		if len(g.tasks) == 1 {
+			copy(p.groups[idx:], p.groups[idx+1:])
+			p.groups[n-1] = nil
+			p.groups = p.groups[:n-1]
+			g.queued = false
+			p.next = idx
+		} else {
+			p.next = idx + 1
+		}
+
+		return g
+	}
+
+	return nil
+}
+
+// WorkGroup represents a group of tasks run by a WorkerPool.
+type WorkGroup struct {
+	pool  *WorkerPool
+	limit int
+
+	tasks   []func()
+	running int
+	queued  bool
+
+	wg sync.WaitGroup
+}
+
+// Submit queues a task to be run by the worker pool.
+//
+// When MaxPending is reached, Submit waits for a task to be started if Wait
+// is set, otherwise it returns ErrorWorkerPoolFull.
+func (g *WorkGroup) Submit(fn func()) error {
+	p := g.pool
+
+	p.mu.Lock()
+	for !p.closed && p.MaxPending > 0 && p.pending >= p.MaxPending {
+		if !p.Wait {
+			p.mu.Unlock()
+			atomic.AddInt64(&p.rejected, 1)
+			return ErrorWorkerPoolFull
+		}
+
+		p.cond.Wait()
+	}
+
+	if p.closed {
+		p.mu.Unlock()
+		return ErrorWorkerPoolClosed
+	}
+
+	g.tasks = append(g.tasks, fn)
+	g.wg.Add(1)
+	p.pending++
+	if !g.queued {
+		p.groups = append(p.groups, g)
+		g.queued = true
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+
+	atomic.AddInt64(&p.submitted, 1)
+	return nil
+}
+
+// Pending returns the number of tasks of the group not yet started.
+func (g *WorkGroup) Pending() int {
+	g.pool.mu.Lock()
+	defer g.pool.mu.Unlock()
+
+	return len(g.tasks)
+}
+
+// Wait blocks until all submitted tasks of the group have run.
+func (g *WorkGroup) Wait() {
+	g.wg.Wait()
+}
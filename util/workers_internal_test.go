@@ -0,0 +1,167 @@
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool_Submit(t *testing.T) {
+	p := NewWorkerPool(4)
+	defer p.Close()
+
+	var count int64
+	g := p.Group(0)
+	for i := 0; i < 100; i++ {
+		if err := g.Submit(func() { atomic.AddInt64(&count, 1) }); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+	g.Wait()
+
+	if count != 100 {
+		t.Fatalf("expected %d tasks run; got %d", 100, count)
+	}
+
+	if s := p.Stats(); s.Submitted != 100 || s.Pending != 0 || s.Running != 0 {
+		t.Fatalf("expected 100 submitted and none pending or running; got %+v", s)
+	}
+}
+
+func TestWorkerPool_GroupLimit(t *testing.T) {
+	p := NewWorkerPool(8)
+	defer p.Close()
+
+	var running, max int64
+	g := p.Group(2)
+	for i := 0; i < 20; i++ {
+		g.Submit(func() {
+			n := atomic.AddInt64(&running, 1)
+			for {
+				m := atomic.LoadInt64(&max)
+				if n <= m || atomic.CompareAndSwapInt64(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		})
+	}
+	g.Wait()
+
+	if max > 2 {
+		t.Fatalf("expected at most %d running tasks; got %d", 2, max)
+	}
+}
+
+func TestWorkerPool_Fair(t *testing.T) {
+	p := NewWorkerPool(1)
+	defer p.Close()
+
+	// Hold the only worker so both groups are queued before any task runs.
+	block := make(chan struct{})
+	hold := p.Group(0)
+	hold.Submit(func() { <-block })
+	for p.Stats().Running != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var mu sync.Mutex
+	order := make([]string, 0)
+	record := func(s string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, s)
+			mu.Unlock()
+		}
+	}
+
+	a, b := p.Group(0), p.Group(0)
+	for i := 0; i < 3; i++ {
+		a.Submit(record("a"))
+	}
+	for i := 0; i < 3; i++ {
+		b.Submit(record("b"))
+	}
+	close(block)
+	a.Wait()
+	b.Wait()
+
+	want := []string{"a", "b", "a", "b", "a", "b"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("expected order %v; got %v", want, order)
+		}
+	}
+}
+
+func TestWorkerPool_MaxPending(t *testing.T) {
+	p := NewWorkerPool(1)
+	p.MaxPending = 1
+	defer p.Close()
+
+	block := make(chan struct{})
+	g := p.Group(0)
+	g.Submit(func() { <-block })
+	for p.Stats().Running != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := g.Submit(func() {}); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	if err := g.Submit(func() {}); err != ErrorWorkerPoolFull {
+		t.Fatalf("expected %v; got %v", ErrorWorkerPoolFull, err)
+	}
+
+	if s := p.Stats(); s.Rejected != 1 {
+		t.Fatalf("expected %d rejected; got %d", 1, s.Rejected)
+	}
+
+	close(block)
+	g.Wait()
+}
+
+func TestWorkerPool_MaxPendingWait(t *testing.T) {
+	p := NewWorkerPool(1)
+	p.MaxPending = 1
+	p.Wait = true
+	defer p.Close()
+
+	var count int64
+	g := p.Group(0)
+	for i := 0; i < 10; i++ {
+		if err := g.Submit(func() {
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&count, 1)
+		}); err != nil {
+			t.Fatalf("unexpected err; got %v", err)
+		}
+	}
+	g.Wait()
+
+	if count != 10 {
+		t.Fatalf("expected %d tasks run; got %d", 10, count)
+	}
+}
+
+func TestWorkerPool_Close(t *testing.T) {
+	p := NewWorkerPool(2)
+
+	var count int64
+	g := p.Group(0)
+	for i := 0; i < 10; i++ {
+		g.Submit(func() { atomic.AddInt64(&count, 1) })
+	}
+	p.Close()
+
+	if count != 10 {
+		t.Fatalf("expected %d tasks run; got %d", 10, count)
+	}
+
+	if err := g.Submit(func() {}); err != ErrorWorkerPoolClosed {
+		t.Fatalf("expected %v; got %v", ErrorWorkerPoolClosed, err)
+	}
+}
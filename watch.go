@@ -0,0 +1,264 @@
+package isenzo
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultChangeLogSize is the default number of changes kept in the change log.
+const defaultChangeLogSize = 1024
+
+// ErrorRevisionCompacted is returned when watching from, or falling behind
+// to, a revision no longer in the change log.
+var ErrorRevisionCompacted = errors.New("revision has been compacted from the change log")
+
+// ChangeType represents the type of a query change.
+type ChangeType string
+
+// Query change types.
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+)
+
+// Change represents a change to a query.
+type Change struct {
+	Revision  uint64     `json:"revision"`
+	Type      ChangeType `json:"type"`
+	Namespace string     `json:"namespace,omitempty"`
+	Id        string     `json:"id"`
+	// Query is the query after the change, or the deleted query.
+	Query Query     `json:"query"`
+	Time  time.Time `json:"time"`
+}
+
+// changeLog is a bounded log of query changes.
+type changeLog struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	changes  []Change
+	size     int
+	revision uint64
+	closed   bool
+	done     chan struct{}
+}
+
+func newChangeLog(size int) *changeLog {
+	if size < 1 {
+		size = 1
+	}
+
+	l := &changeLog{
+		changes: make([]Change, 0, size),
+		size:    size,
+		done:    make(chan struct{}),
+	}
+	l.cond = sync.NewCond(&l.mu)
+
+	return l
+}
+
+// append adds a change with the next revision, dropping the oldest change
+// when the log is full. It returns the revision of the change.
+func (l *changeLog) append(c Change) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.revision++
+	c.Revision = l.revision
+
+	if len(l.changes) >= l.size {
+		copy(l.changes, l.changes[1:])
+		l.changes = l.changes[:len(l.changes)-1]
+	}
+	l.changes = append(l.changes, c)
+
+	l.cond.Broadcast()
+	return c.Revision
+}
+
+// oldest returns the oldest revision in the log. The lock must be held.
+func (l *changeLog) oldest() uint64 {
+	return l.revision - uint64(len(l.changes)) + 1
+}
+
+// wait blocks until there are changes from the given revision, returning
+// them, or nil once the log or done is closed.
+func (l *changeLog) wait(from uint64, done chan struct{}) ([]Change, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for from > l.revision && !l.closed && !isClosed(done) {
+		l.cond.Wait()
+	}
+
+	if l.closed || isClosed(done) {
+		return nil, nil
+	}
+
+	oldest := l.oldest()
+	if from < oldest {
+		return nil, ErrorRevisionCompacted
+	}
+
+	changes := make([]Change, l.revision-from+1)
+	copy(changes, l.changes[from-oldest:])
+	return changes, nil
+}
+
+// wake wakes all waiting watchers.
+func (l *changeLog) wake() {
+	l.mu.Lock()
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// close ends all watchers, including those blocked delivering a change.
+func (l *changeLog) close() {
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// isClosed determines if the channel is closed.
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// recordChange appends a query change to the change log and the query
+// history. The cache lock must be held for writing.
+func (p *Percolator) recordChange(typ ChangeType, ns string, qry Query) {
+	now := p.now()
+	rev := p.changes.append(Change{
+		Type:      typ,
+		Namespace: ns,
+		Id:        qry.Id,
+		Query:     qry,
+		Time:      now,
+	})
+
+	p.recordVersion(key(ns, qry.Id), QueryVersion{
+		Revision: rev,
+		Query:    qry,
+		Deleted:  typ == ChangeDeleted,
+		Time:     now,
+	})
+}
+
+// Revision returns the revision of the latest query change.
+func (p *Percolator) Revision() uint64 {
+	p.changes.mu.Lock()
+	defer p.changes.mu.Unlock()
+
+	return p.changes.revision
+}
+
+// Watch subscribes to the query changes after the given revision, in all
+// namespaces. Watching from revision 0 delivers all changes, as long as none
+// have been compacted from the change log.
+//
+// If a change after the revision is no longer in the change log,
+// ErrorRevisionCompacted is returned.
+func (p *Percolator) Watch(fromRevision uint64) (*Watcher, error) {
+	p.stateLock.RLock()
+	defer p.stateLock.RUnlock()
+
+	if p.state != stateOpen {
+		return nil, ErrorPercolatorClosed
+	}
+
+	p.changes.mu.Lock()
+	if fromRevision+1 < p.changes.oldest() {
+		p.changes.mu.Unlock()
+		return nil, ErrorRevisionCompacted
+	}
+	p.changes.mu.Unlock()
+
+	w := &Watcher{
+		log:  p.changes,
+		next: fromRevision + 1,
+		ch:   make(chan Change, 64),
+		done: make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// Watcher represents a subscription to query changes.
+type Watcher struct {
+	log  *changeLog
+	next uint64
+	ch   chan Change
+	done chan struct{}
+	once sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Changes returns the channel changes are delivered on, in revision order.
+//
+// The channel is closed when the watcher or Percolator is closed, or when
+// the watcher falls behind the change log, in which case Err returns
+// ErrorRevisionCompacted.
+func (w *Watcher) Changes() <-chan Change {
+	return w.ch
+}
+
+// Err returns the error that ended the watcher, if any.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+// Close stops the watcher.
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		close(w.done)
+		w.log.wake()
+	})
+}
+
+// run delivers changes until the watcher is done.
+func (w *Watcher) run() {
+	defer close(w.ch)
+
+	for {
+		changes, err := w.log.wait(w.next, w.done)
+		if err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+			return
+		}
+
+		if changes == nil {
+			return
+		}
+
+		for _, c := range changes {
+			select {
+			case w.ch <- c:
+			case <-w.done:
+				return
+			case <-w.log.done:
+				return
+			}
+		}
+		w.next = changes[len(changes)-1].Revision + 1
+	}
+}
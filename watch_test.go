@@ -0,0 +1,210 @@
+package isenzo_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nrwiersma/isenzo"
+)
+
+func TestPercolator_Watch(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	w, err := p.Watch(0)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer w.Close()
+
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "foo:bar"), isenzo.NewQuery("2", "foo:bar")})
+	p.Update([]isenzo.Query{isenzo.NewQuery("1", "baz:bat")})
+	p.Delete([]string{"2"})
+
+	want := []struct {
+		typ isenzo.ChangeType
+		id  string
+	}{
+		{isenzo.ChangeAdded, "1"},
+		{isenzo.ChangeAdded, "2"},
+		{isenzo.ChangeUpdated, "1"},
+		{isenzo.ChangeDeleted, "2"},
+	}
+
+	for i, c := range want {
+		got := receiveChange(t, w)
+		if got.Revision != uint64(i+1) || got.Type != c.typ || got.Id != c.id {
+			t.Fatalf("expected change %d %s %s; got %+v", i+1, c.typ, c.id, got)
+		}
+	}
+
+	if rev := p.Revision(); rev != 4 {
+		t.Fatalf("expected revision %d; got %d", 4, rev)
+	}
+}
+
+func TestPercolator_WatchFromRevision(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		p.Update([]isenzo.Query{isenzo.NewQuery(strconv.Itoa(i), "foo:bar")})
+	}
+
+	w, err := p.Watch(2)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer w.Close()
+
+	if c := receiveChange(t, w); c.Revision != 3 || c.Id != "2" {
+		t.Fatalf("expected change 3 for query 2; got %+v", c)
+	}
+}
+
+func TestPercolator_WatchCompacted(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithChangeLog(2))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		p.Update([]isenzo.Query{isenzo.NewQuery(strconv.Itoa(i), "foo:bar")})
+	}
+
+	if _, err := p.Watch(0); err != isenzo.ErrorRevisionCompacted {
+		t.Fatalf("expected %v; got %v", isenzo.ErrorRevisionCompacted, err)
+	}
+
+	w, err := p.Watch(1)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	w.Close()
+}
+
+func TestPercolator_WatchLagging(t *testing.T) {
+	p, err := isenzo.NewPercolator(isenzo.WithChangeLog(2))
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	w, err := p.Watch(0)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+	defer w.Close()
+
+	for i := 0; i < 100; i++ {
+		p.Update([]isenzo.Query{isenzo.NewQuery(strconv.Itoa(i), "foo:bar")})
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-w.Changes():
+			if ok {
+				continue
+			}
+
+			if err := w.Err(); err != isenzo.ErrorRevisionCompacted {
+				t.Fatalf("expected %v; got %v", isenzo.ErrorRevisionCompacted, err)
+			}
+			return
+
+		case <-timeout:
+			t.Fatal("expected watcher to fall behind")
+		}
+	}
+}
+
+func TestPercolator_WatchClose(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	w, err := p.Watch(0)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	p.Close()
+
+	select {
+	case _, ok := <-w.Changes():
+		if ok {
+			t.Fatal("expected no changes")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected changes to be closed")
+	}
+
+	if err := w.Err(); err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+}
+
+func TestPercolator_WatchCloseBlocked(t *testing.T) {
+	p, err := isenzo.NewPercolator()
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		p.Update([]isenzo.Query{isenzo.NewQuery(strconv.Itoa(i), "foo:bar")})
+	}
+
+	// The changes do not fit the channel, so the watcher blocks delivering them.
+	w, err := p.Watch(0)
+	if err != nil {
+		t.Fatalf("unexpected err; got %v", err)
+	}
+
+	for i := 0; i < 100 && len(w.Changes()) < cap(w.Changes()); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	received := 0
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-w.Changes():
+			if ok {
+				received++
+				continue
+			}
+
+			if received >= 100 {
+				t.Fatalf("expected the watcher to stop delivering on close; got %d changes", received)
+			}
+			return
+
+		case <-timeout:
+			t.Fatal("expected changes to be closed")
+		}
+	}
+}
+
+func receiveChange(t *testing.T, w *isenzo.Watcher) isenzo.Change {
+	select {
+	case c, ok := <-w.Changes():
+		if !ok {
+			t.Fatalf("expected change; got closed watcher with %v", w.Err())
+		}
+		return c
+
+	case <-time.After(time.Second):
+		t.Fatal("expected change; got none")
+	}
+
+	return isenzo.Change{}
+}